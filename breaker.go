@@ -0,0 +1,121 @@
+package ldapool
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// breakerState is the state of the circuit breaker around connection creation
+type breakerState int
+
+const (
+	// breakerClosed lets every dial through
+	breakerClosed breakerState = iota
+	// breakerOpen fails every dial fast until the backoff has passed
+	breakerOpen
+	// breakerHalfOpen lets a single trial dial through
+	breakerHalfOpen
+)
+
+// breaker fails connection creation fast after repeated failures. Each time it
+// opens it stays open twice as long as the last time, up to max, with jitter.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	state     breakerState
+	failures  int
+	trips     int
+	openUntil time.Time
+}
+
+// newBreaker creates a breaker opening after threshold consecutive failures.
+// A threshold of 0 disables it.
+func newBreaker(threshold int, base, max time.Duration) *breaker {
+	return &breaker{threshold: threshold, base: base, max: max}
+}
+
+// allow reports whether a dial may go ahead, returning ErrCircuitOpen if not
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// The trial dial is still in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record feeds the outcome of an allowed dial back into the breaker
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.state = breakerClosed
+		b.failures, b.trips = 0, 0
+		return
+	}
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.tripLocked()
+	case breakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.tripLocked()
+		}
+	}
+}
+
+// abort gives up an allowed dial that ended without an outcome, such as one
+// cancelled by its caller. A half-open breaker lets the next dial be the trial.
+func (b *breaker) abort() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// tripLocked opens the breaker. Must be called with b.mu held.
+func (b *breaker) tripLocked() {
+	b.trips++
+	backoff := b.max
+	if shift := b.trips - 1; shift < 32 && b.base<<shift < b.max {
+		backoff = b.base << shift
+	}
+	// Jitter within the upper half so recovering clients do not dial in lockstep
+	backoff = backoff/2 + rand.N(backoff/2+1)
+
+	b.state = breakerOpen
+	b.failures = 0
+	b.openUntil = time.Now().Add(backoff)
+}
+
+// isOpen reports whether dials are currently being rejected
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerHalfOpen || (b.state == breakerOpen && time.Now().Before(b.openUntil))
+}
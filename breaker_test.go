@@ -0,0 +1,202 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestBreaker(t *testing.T) {
+	failure := errors.New("dial failed")
+
+	t.Run("Opens after threshold failures", func(t *testing.T) {
+		b := newBreaker(3, time.Minute, time.Hour)
+		for i := 0; i < 3; i++ {
+			if err := b.allow(); err != nil {
+				t.Fatalf("Dial %d rejected: %v", i, err)
+			}
+			b.record(failure)
+		}
+		if err := b.allow(); err != ErrCircuitOpen {
+			t.Errorf("Expected ErrCircuitOpen, got %v", err)
+		}
+	})
+
+	t.Run("Success resets the failure count", func(t *testing.T) {
+		b := newBreaker(2, time.Minute, time.Hour)
+		b.record(failure)
+		b.record(nil)
+		b.record(failure)
+		if err := b.allow(); err != nil {
+			t.Errorf("Expected breaker to stay closed, got %v", err)
+		}
+	})
+
+	t.Run("Half-open allows a single trial", func(t *testing.T) {
+		b := newBreaker(1, time.Millisecond, time.Millisecond)
+		b.record(failure)
+		time.Sleep(2 * time.Millisecond)
+
+		if err := b.allow(); err != nil {
+			t.Fatalf("Expected trial dial, got %v", err)
+		}
+		if err := b.allow(); err != ErrCircuitOpen {
+			t.Errorf("Expected concurrent dial to be rejected, got %v", err)
+		}
+		b.record(nil)
+		if err := b.allow(); err != nil {
+			t.Errorf("Expected breaker to close after a successful trial, got %v", err)
+		}
+	})
+
+	t.Run("Backoff grows exponentially up to max", func(t *testing.T) {
+		b := newBreaker(1, 10*time.Millisecond, 40*time.Millisecond)
+		for i, max := range []time.Duration{10, 20, 40, 40} {
+			max *= time.Millisecond
+			b.mu.Lock()
+			b.state = breakerHalfOpen
+			b.mu.Unlock()
+
+			start := time.Now()
+			b.record(failure)
+			b.mu.Lock()
+			backoff := b.openUntil.Sub(start)
+			b.mu.Unlock()
+			if backoff < max/2 || backoff > max+time.Millisecond {
+				t.Errorf("Trip %d: backoff %v outside [%v, %v]", i+1, backoff, max/2, max)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		b := newBreaker(0, time.Minute, time.Hour)
+		for i := 0; i < 10; i++ {
+			b.record(failure)
+		}
+		if err := b.allow(); err != nil {
+			t.Errorf("Expected disabled breaker to allow dials, got %v", err)
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.BreakerThreshold = 2
+	config.BreakerBackoff = 20 * time.Millisecond
+
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	server.setPassword("changed")
+	for i := 0; i < 2; i++ {
+		if _, err := pool.GetConnection(context.Background()); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("Expected bind failure, got %v", err)
+		}
+	}
+
+	binds := server.Requests(ldap.ApplicationBindRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.GetConnection(context.Background()); err != ErrCircuitOpen {
+				t.Errorf("Expected ErrCircuitOpen, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := server.Requests(ldap.ApplicationBindRequest); got != binds {
+		t.Errorf("Expected no dials while the circuit is open, got %d", got-binds)
+	}
+	if !pool.PoolStats().CircuitOpen {
+		t.Error("Expected stats to report the open circuit")
+	}
+	if open, _ := pool.Stats(); open != 0 {
+		t.Errorf("Expected 0 open connections, got %d", open)
+	}
+
+	server.setPassword(config.AdminPass)
+	time.Sleep(config.BreakerBackoff + 5*time.Millisecond)
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Expected trial dial to succeed, got %v", err)
+	}
+	conn.Close()
+	if pool.PoolStats().CircuitOpen {
+		t.Error("Expected circuit to close after a successful trial")
+	}
+}
+
+func TestCancelledDialIsNotAFailure(t *testing.T) {
+	// A server that accepts connections but never answers the TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := getTestConfig()
+	config.Url = "ldaps://" + ln.Addr().String()
+	config.LazyConnect = true
+	config.BreakerThreshold = 1
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.GetConnection(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetConnection(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	stats := pool.PoolStats()
+	if stats.CircuitOpen || stats.DialFailures != 0 || stats.LastError != nil {
+		t.Errorf("Expected cancelled dials not to count as failures, got %+v", stats)
+	}
+	if got := pool.servers.candidates(); len(got) != 1 {
+		t.Errorf("Expected the server to stay in rotation, got %v", got)
+	}
+	if open, _ := pool.Stats(); open != 0 {
+		t.Errorf("Expected the reserved slots back, got %d open", open)
+	}
+}
+
+func TestBreakerAbort(t *testing.T) {
+	b := newBreaker(1, time.Millisecond, time.Millisecond)
+	b.record(errors.New("dial failed"))
+	time.Sleep(2 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a trial dial, got %v", err)
+	}
+	b.abort()
+	if err := b.allow(); err != nil {
+		t.Errorf("Expected another trial after an aborted one, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// The *Context methods below are the context-aware counterparts of the
+// operations in ops.go. The context's deadline lowers the search TimeLimit
+// and bounds writes to the server. When the context is done first the
+// request is abandoned, the method returns the context's error and the
+// connection is marked suspect: once returned it is validated before it is
+// reused.
+
+// errConnClosing mirrors the error go-ldap returns for requests on a closed
+// connection
+var errConnClosing = ldap.NewError(ldap.ErrorNetwork, errors.New("ldap: connection closed"))
+
+// finished is a closed channel, for suspect connections that have no
+// operation left running
+var finished = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// withTimeLimit returns req with its TimeLimit lowered to the time left until
+// ctx's deadline, rounded up to whole seconds
+func withTimeLimit(ctx context.Context, req *ldap.SearchRequest) *ldap.SearchRequest {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return req
+	}
+	limit := int(math.Ceil(time.Until(deadline).Seconds()))
+	if limit < 1 {
+		limit = 1
+	}
+	if req.TimeLimit > 0 && req.TimeLimit <= limit {
+		return req
+	}
+	limited := *req
+	limited.TimeLimit = limit
+	return &limited
+}
+
+// setWriteDeadline bounds writes to the server by ctx's deadline and returns
+// a func that lifts the bound again
+func (lc *LdapConn) setWriteDeadline(ctx context.Context) func() {
+	deadline, ok := ctx.Deadline()
+	if !ok || lc.wire == nil {
+		return func() {}
+	}
+	lc.wire.SetWriteDeadline(deadline)
+	return func() { lc.wire.SetWriteDeadline(time.Time{}) }
+}
+
+// lastSent returns the ID of the last request sent on the connection
+func (lc *LdapConn) lastSent() int64 {
+	if lc.wire == nil {
+		return 0
+	}
+	return lc.wire.lastSent()
+}
+
+// abandon abandons the request sent after the one with ID since, if any, as
+// ctx is done. The connection is marked suspect until done is closed by the
+// operation that sent the request.
+func (lc *LdapConn) abandon(ctx context.Context, since int64, done chan struct{}) {
+	if lc.wire == nil || lc.pool == nil {
+		return
+	}
+	lcp := lc.pool
+	if id := lc.wire.lastSent(); id > since {
+		err := lc.wire.abandon(id, lcp.config.ConnTimeout)
+		atomic.AddInt64(&lcp.abandoned, 1)
+		lcp.logger.Debug("ldap request abandoned", slog.String("server", redactURL(lc.server)),
+			slog.Int64("message_id", id), slog.Any("reason", ctx.Err()), slog.Any("error", err))
+	}
+
+	pooled := lc
+	if lc.pooled != nil {
+		pooled = lc.pooled
+	}
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	pooled.suspect = done
+}
+
+// takeSuspect clears the suspect mark of conn, returning the channel that is
+// closed once its abandoned operation has finished, or nil if it had none
+func (lcp *LdapConnPool) takeSuspect(conn *LdapConn) chan struct{} {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	done := conn.suspect
+	conn.suspect = nil
+	return done
+}
+
+// revalidate returns a suspect connection to the pool once its abandoned
+// operation has finished and it answers a probe, each within ConnTimeout, and
+// closes it otherwise. The connection keeps its slot meanwhile.
+func (lcp *LdapConnPool) revalidate(conn *LdapConn, done chan struct{}) {
+	lastUsed := time.Now()
+	timer := time.NewTimer(lcp.config.ConnTimeout)
+	defer timer.Stop()
+
+	err := ErrTimeout
+	select {
+	case <-done:
+		err = conn.ping(lcp.config.ConnTimeout)
+	case <-timer.C:
+		// The server may never answer an abandoned request
+	}
+	if err != nil {
+		lcp.mu.Lock()
+		defer lcp.mu.Unlock()
+		lcp.closeConnLocked(conn, closeBroken)
+		return
+	}
+	lcp.putConnection(conn, lastUsed)
+}
+
+// run runs op on lc, abandoning its request when ctx is done first
+func run[T any](ctx context.Context, lc *LdapConn, op func() (T, error)) (T, error) {
+	var zero T
+	if err := lc.usable(); err != nil {
+		return zero, err
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	defer lc.setWriteDeadline(ctx)()
+
+	type outcome struct {
+		value T
+		err   error
+	}
+	since := lc.lastSent()
+	results := make(chan outcome, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, err := op()
+		results <- outcome{value, err}
+	}()
+
+	select {
+	case res := <-results:
+		return res.value, res.err
+	case <-ctx.Done():
+		select {
+		case res := <-results:
+			// Finished just in time
+			return res.value, res.err
+		default:
+		}
+		lc.abandon(ctx, since, done)
+		return zero, ctx.Err()
+	}
+}
+
+// runErr runs an op that only returns an error, see run
+func runErr(ctx context.Context, lc *LdapConn, op func() error) error {
+	_, err := run(ctx, lc, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+// SearchContext performs the given search request, abandoning it when ctx is
+// done. The request's TimeLimit is lowered to the time left until ctx's
+// deadline.
+func (lc *LdapConn) SearchContext(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	err := lc.stream(ctx, req, 0, func(res *ldap.SearchSingleResult) bool {
+		switch {
+		case res.Entry != nil:
+			result.Entries = append(result.Entries, res.Entry)
+		case res.Referral != "":
+			result.Referrals = append(result.Referrals, res.Referral)
+		default:
+			result.Controls = append(result.Controls, res.Controls...)
+		}
+		return true
+	})
+	return result, err
+}
+
+// stream runs req with SearchAsync, passing each result to yield. The search
+// is abandoned when ctx is done or yield returns false to stop it early.
+// Results are read as yield returns, so a slow consumer holds back the search
+// once bufferSize results are queued.
+func (lc *LdapConn) stream(ctx context.Context, req *ldap.SearchRequest, bufferSize int, yield func(*ldap.SearchSingleResult) bool) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if lc.IsClosing() {
+		return errConnClosing
+	}
+	span := lc.startOp(ctx, "ldap.search", attrBaseDN.String(req.BaseDN), attrScope.String(ldap.ScopeMap[req.Scope]))
+	defer lc.setWriteDeadline(ctx)()
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	since := lc.lastSent()
+	response := lc.Conn.SearchAsync(searchCtx, withTimeLimit(ctx, req), bufferSize)
+	stopped := false
+	for response.Next() {
+		// Results already queued are not handed out once ctx is done
+		if ctx.Err() != nil {
+			break
+		}
+		res := &ldap.SearchSingleResult{Entry: response.Entry(), Referral: response.Referral(), Controls: response.Controls()}
+		if !yield(res) {
+			stopped = true
+			break
+		}
+	}
+
+	err := response.Err()
+	if stopped || ctx.Err() != nil {
+		// Let the search stop reading its responses, then abandon it
+		cancel()
+		for response.Next() {
+		}
+		lc.abandon(searchCtx, since, finished)
+		err = ctx.Err()
+	}
+	endOpSpan(span, err)
+	return err
+}
+
+// AddContext performs the given add request, abandoning it when ctx is done
+func (lc *LdapConn) AddContext(ctx context.Context, req *ldap.AddRequest) error {
+	span := lc.startOp(ctx, "ldap.add", attrDN.String(req.DN))
+	err := runErr(ctx, lc, func() error {
+		return lc.Conn.Add(req)
+	})
+	endOpSpan(span, err)
+	return err
+}
+
+// ModifyContext performs the given modify request, abandoning it when ctx is
+// done
+func (lc *LdapConn) ModifyContext(ctx context.Context, req *ldap.ModifyRequest) error {
+	span := lc.startOp(ctx, "ldap.modify", attrDN.String(req.DN))
+	err := runErr(ctx, lc, func() error {
+		return lc.Conn.Modify(req)
+	})
+	endOpSpan(span, err)
+	return err
+}
+
+// ModifyDNContext renames or moves the entry of the given request, abandoning
+// the request when ctx is done
+func (lc *LdapConn) ModifyDNContext(ctx context.Context, req *ldap.ModifyDNRequest) error {
+	span := lc.startOp(ctx, "ldap.modify_dn", attrDN.String(req.DN))
+	err := runErr(ctx, lc, func() error {
+		return lc.Conn.ModifyDN(req)
+	})
+	endOpSpan(span, err)
+	return err
+}
+
+// DelContext performs the given delete request, abandoning it when ctx is
+// done
+func (lc *LdapConn) DelContext(ctx context.Context, req *ldap.DelRequest) error {
+	span := lc.startOp(ctx, "ldap.delete", attrDN.String(req.DN))
+	err := runErr(ctx, lc, func() error {
+		return lc.Conn.Del(req)
+	})
+	endOpSpan(span, err)
+	return err
+}
+
+// CompareContext checks whether the attribute of dn has the given value,
+// abandoning the request when ctx is done
+func (lc *LdapConn) CompareContext(ctx context.Context, dn, attribute, value string) (bool, error) {
+	span := lc.startOp(ctx, "ldap.compare", attrDN.String(dn))
+	ok, err := run(ctx, lc, func() (bool, error) {
+		return lc.Conn.Compare(dn, attribute, value)
+	})
+	endOpSpan(span, err)
+	return ok, err
+}
+
+// PasswordModifyContext performs the given password modify extended
+// operation, abandoning it when ctx is done
+func (lc *LdapConn) PasswordModifyContext(ctx context.Context, req *ldap.PasswordModifyRequest) (*ldap.PasswordModifyResult, error) {
+	span := lc.startOp(ctx, "ldap.password_modify", attrDN.String(req.UserIdentity))
+	result, err := run(ctx, lc, func() (*ldap.PasswordModifyResult, error) {
+		return lc.Conn.PasswordModify(req)
+	})
+	endOpSpan(span, err)
+	return result, err
+}
@@ -0,0 +1,177 @@
+package ldapool
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// waitRequests blocks until the server has received n requests of the given
+// operation
+func waitRequests(t *testing.T, server *fakeServer, tag ber.Tag, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for server.Requests(tag) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d requests with tag %d, got %d", n, tag, server.Requests(tag))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSearchContextAbandon(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	accepted := server.Accepted()
+
+	server.setHang(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = conn.SearchContext(ctx, ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the search to be cut short by the deadline, got %v", err)
+	}
+	waitRequests(t, server, ldap.ApplicationAbandonRequest, 1)
+	if n := pool.PoolStats().Abandoned; n != 1 {
+		t.Errorf("Expected one abandoned request, got %d", n)
+	}
+
+	// The suspect connection passes validation and is reused
+	server.setHang(false)
+	conn.Close()
+	next, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if next.pooled != conn.pooled {
+		t.Error("Expected the validated connection to be reused")
+	}
+	next.Close()
+	if stats := pool.PoolStats(); stats.BrokenClosed != 0 || server.Accepted() != accepted {
+		t.Errorf("Expected no reconnect, got %d broken and %d new connections", stats.BrokenClosed, server.Accepted()-accepted)
+	}
+}
+
+func TestSuspectConnFailsValidation(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	config.ConnTimeout = 50 * time.Millisecond
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	server.setHang(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := pool.GetConnection(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	searches := server.Requests(ldap.ApplicationSearchRequest)
+	go func() {
+		for server.Requests(ldap.ApplicationSearchRequest) == searches {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := conn.SearchContext(ctx, rootDSERequest); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the search to be canceled, got %v", err)
+	}
+
+	// The server still does not answer, so the probe fails and the connection is closed
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.PoolStats().BrokenClosed == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the suspect connection to be closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if open := pool.PoolStats().Open; open != 0 {
+		t.Errorf("Expected no open connections, got %d", open)
+	}
+}
+
+func TestPoolSearchDeadline(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	server.setHang(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = pool.Search(ctx, rootDSERequest)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline to abort the search, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Search returned %v after the deadline", elapsed)
+	}
+}
+
+func TestWithTimeLimit(t *testing.T) {
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil)
+	if got := withTimeLimit(context.Background(), req); got != req {
+		t.Error("Expected a request without deadline to be left alone")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+	got := withTimeLimit(ctx, req)
+	if got.TimeLimit != 3 || req.TimeLimit != 0 {
+		t.Errorf("Expected a TimeLimit of 3s on a copy, got %d (original %d)", got.TimeLimit, req.TimeLimit)
+	}
+
+	req.TimeLimit = 1
+	if got := withTimeLimit(ctx, req); got != req {
+		t.Error("Expected a lower TimeLimit to be kept")
+	}
+}
+
+func TestStartTLSRejected(t *testing.T) {
+	server := newFakeServer(t)
+	pool, err := NewPool(server.config())
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.StartTLS(&tls.Config{}); !errors.Is(err, ErrStartTLS) {
+		t.Errorf("Expected ErrStartTLS, got %v", err)
+	}
+	if n := server.Requests(ldap.ApplicationExtendedRequest); n != 0 {
+		t.Errorf("Expected no StartTLS request sent, got %d", n)
+	}
+	if _, err := conn.Search(rootDSERequest); err != nil {
+		t.Errorf("Search after the rejected StartTLS failed: %v", err)
+	}
+}
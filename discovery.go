@@ -0,0 +1,121 @@
+package ldapool
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SRVResolver looks up DNS SRV records. *net.Resolver satisfies it.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// discoverServers resolves the SRV records of config.SRVDomain into server
+// urls grouped by priority, lowest first, each group ordered randomly by
+// weight
+func discoverServers(ctx context.Context, config LdapConfig) ([][]string, error) {
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, config.SRVService, "tcp", config.SRVDomain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV records: %w", err)
+	}
+
+	scheme := "ldap://"
+	if config.SRVService == "ldaps" {
+		scheme = "ldaps://"
+	}
+
+	var groups [][]string
+	var priority uint16
+	for _, srv := range orderSRV(records) {
+		host := strings.TrimSuffix(srv.Target, ".")
+		// A target of "." means the service is decidedly not available
+		if host == "" {
+			continue
+		}
+		if len(groups) == 0 || srv.Priority != priority {
+			groups = append(groups, nil)
+			priority = srv.Priority
+		}
+		last := len(groups) - 1
+		groups[last] = append(groups[last], scheme+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no SRV records found for _%s._tcp.%s", config.SRVService, config.SRVDomain)
+	}
+	return groups, nil
+}
+
+// orderSRV sorts records by priority and shuffles each priority group using
+// the weighted selection of RFC 2782
+func orderSRV(records []*net.SRV) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		shuffleByWeight(sorted[start:end])
+		start = end
+	}
+	return sorted
+}
+
+// shuffleByWeight orders records with the weighted selection of RFC 2782:
+// each position is filled by picking a random number in [0, total weight] and
+// taking the first remaining record whose running weight sum reaches it.
+// Zero weight records come first, so they are picked only when the number is
+// 0.
+func shuffleByWeight(records []*net.SRV) {
+	rand.Shuffle(len(records), func(i, j int) { records[i], records[j] = records[j], records[i] })
+	for i := range records {
+		rest := records[i:]
+		sort.SliceStable(rest, func(a, b int) bool {
+			return rest[a].Weight == 0 && rest[b].Weight != 0
+		})
+		total := 0
+		for _, srv := range rest {
+			total += int(srv.Weight)
+		}
+		pick := rand.IntN(total + 1)
+		sum := 0
+		for j, srv := range rest {
+			sum += int(srv.Weight)
+			if sum >= pick {
+				rest[0], rest[j] = rest[j], rest[0]
+				break
+			}
+		}
+	}
+}
+
+// refreshServers resolves the SRV records again and swaps in the new server
+// list. Known servers keep their order within their priority, so a refresh
+// does not drain connections only because the weighted order came out
+// differently. The current list is kept if the lookup fails.
+func (lcp *LdapConnPool) refreshServers() error {
+	ctx, cancel := context.WithTimeout(context.Background(), lcp.config.ConnTimeout)
+	defer cancel()
+
+	groups, err := discoverServers(ctx, lcp.config)
+	if err != nil {
+		return err
+	}
+	lcp.servers.setGroups(groups)
+	lcp.cleanupExpiredConnections()
+	return nil
+}
@@ -0,0 +1,233 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeResolver answers SRV lookups from an in-memory record set
+type fakeResolver struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	err     error
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return "_" + service + "._" + proto + "." + name, r.records, nil
+}
+
+// set replaces the records and the lookup error
+func (r *fakeResolver) set(records []*net.SRV, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records, r.err = records, err
+}
+
+// srvFor returns an SRV record pointing at a fake server
+func srvFor(t *testing.T, s *fakeServer, priority, weight uint16) *net.SRV {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to split address: %v", err)
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.SRV{Target: host + ".", Port: uint16(p), Priority: priority, Weight: weight}
+}
+
+func TestOrderSRV(t *testing.T) {
+	t.Run("Lower priority first", func(t *testing.T) {
+		records := []*net.SRV{
+			{Target: "c.", Priority: 20},
+			{Target: "a.", Priority: 0},
+			{Target: "b.", Priority: 10},
+		}
+		got := orderSRV(records)
+		for i, want := range []string{"a.", "b.", "c."} {
+			if got[i].Target != want {
+				t.Errorf("Position %d: expected %s, got %s", i, want, got[i].Target)
+			}
+		}
+	})
+
+	t.Run("Weight decides within a priority", func(t *testing.T) {
+		records := []*net.SRV{
+			{Target: "light.", Priority: 0, Weight: 1},
+			{Target: "heavy.", Priority: 0, Weight: 98},
+		}
+		heavy := 0
+		for i := 0; i < 1000; i++ {
+			if orderSRV(records)[0].Target == "heavy." {
+				heavy++
+			}
+		}
+		if heavy < 900 {
+			t.Errorf("Expected heavy record first most of the time, got %d/1000", heavy)
+		}
+		if heavy == 1000 {
+			t.Error("Expected light record to be picked first occasionally")
+		}
+	})
+
+	t.Run("Zero weight is picked only on a zero draw", func(t *testing.T) {
+		records := []*net.SRV{
+			{Target: "weighted.", Priority: 0, Weight: 9},
+			{Target: "zero.", Priority: 0, Weight: 0},
+		}
+		zero := 0
+		for i := 0; i < 1000; i++ {
+			if orderSRV(records)[0].Target == "zero." {
+				zero++
+			}
+		}
+		// RFC 2782 draws from [0, 9], so the zero weight record leads 1 in 10
+		if zero < 50 || zero > 150 {
+			t.Errorf("Expected zero weight record first about 100/1000 times, got %d", zero)
+		}
+	})
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	t.Run("Pool dials discovered servers", func(t *testing.T) {
+		server := newFakeServer(t)
+		resolver := &fakeResolver{records: []*net.SRV{srvFor(t, server, 0, 0)}}
+
+		config := server.config()
+		config.Url = ""
+		config.SRVDomain = "eryajf.net"
+		config.Resolver = resolver
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		conn, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != server.url {
+			t.Errorf("Expected connection to %s, got %s", server.url, conn.Server())
+		}
+	})
+
+	t.Run("Refresh swaps servers", func(t *testing.T) {
+		old := newFakeServer(t)
+		replacement := newFakeServer(t)
+		resolver := &fakeResolver{records: []*net.SRV{srvFor(t, old, 0, 0)}}
+
+		config := old.config()
+		config.SRVDomain = "eryajf.net"
+		config.Resolver = resolver
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		resolver.set([]*net.SRV{srvFor(t, replacement, 0, 0)}, nil)
+		if err := pool.refreshServers(); err != nil {
+			t.Fatalf("Failed to refresh servers: %v", err)
+		}
+
+		conn, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != replacement.url {
+			t.Errorf("Expected connection to %s, got %s", replacement.url, conn.Server())
+		}
+
+		// A failed lookup keeps the current servers
+		resolver.set(nil, errors.New("SERVFAIL"))
+		if err := pool.refreshServers(); err == nil {
+			t.Error("Expected refresh to fail")
+		}
+		if got := pool.servers.candidates(); len(got) != 1 || got[0] != replacement.url {
+			t.Errorf("Expected servers to be kept, got %v", got)
+		}
+	})
+
+	t.Run("Refresh keeps connections to equal priority servers", func(t *testing.T) {
+		a := newFakeServer(t)
+		b := newFakeServer(t)
+		resolver := &fakeResolver{records: []*net.SRV{srvFor(t, a, 0, 10), srvFor(t, b, 0, 10)}}
+
+		config := a.config()
+		config.Url = ""
+		config.SRVDomain = "eryajf.net"
+		config.Resolver = resolver
+		config.ServerStrategy = StrategyFailover
+		config.MaxIdle = 2
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		// Hold a connection to each server, then return both to the pool
+		pool.servers.markDown(b.url)
+		first, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		pool.servers.markUp(b.url)
+		pool.servers.markDown(a.url)
+		second, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		pool.servers.markUp(a.url)
+		if first.Server() == second.Server() {
+			t.Fatalf("Expected connections to both servers, got %s twice", first.Server())
+		}
+		first.Close()
+		second.Close()
+
+		accepted := a.Accepted() + b.Accepted()
+		for i := 0; i < 50; i++ {
+			if err := pool.refreshServers(); err != nil {
+				t.Fatalf("Failed to refresh servers: %v", err)
+			}
+		}
+		if stats := pool.PoolStats(); stats.Idle != 2 {
+			t.Errorf("Expected refreshes to keep both idle connections, got %d", stats.Idle)
+		}
+		if got := a.Accepted() + b.Accepted(); got != accepted {
+			t.Errorf("Expected no redials, got %d", got-accepted)
+		}
+	})
+
+	t.Run("No usable records", func(t *testing.T) {
+		config := getTestConfig()
+		config.SRVDomain = "eryajf.net"
+		config.Resolver = &fakeResolver{records: []*net.SRV{{Target: "."}}}
+		if _, err := NewPool(config); err == nil {
+			t.Error("Expected pool creation to fail without servers")
+		}
+	})
+
+	t.Run("ldaps service", func(t *testing.T) {
+		config := getTestConfig()
+		config.SRVDomain = "eryajf.net"
+		config.SRVService = "ldaps"
+		config.Resolver = &fakeResolver{records: []*net.SRV{{Target: "dc1.eryajf.net.", Port: 636}}}
+		groups, err := discoverServers(context.Background(), config)
+		if err != nil {
+			t.Fatalf("Failed to discover servers: %v", err)
+		}
+		if len(groups) != 1 || len(groups[0]) != 1 || groups[0][0] != "ldaps://dc1.eryajf.net:636" {
+			t.Errorf("Unexpected urls %v", groups)
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package ldapool
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeServer is a minimal in-process LDAP server used by tests that must not
+// depend on a real directory being available
+type fakeServer struct {
+	t   *testing.T
+	ln  net.Listener
+	url string
+
+	// bindDelay is applied before answering every bind request
+	bindDelay time.Duration
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	password string
+	requests map[ber.Tag]int
+
+	accepted int32
+	live     int32
+	maxLive  int32
+	hang     int32
+	dropNext int32
+	entries  int32
+}
+
+// newFakeServer starts a fake LDAP server on a random local port
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := &fakeServer{
+		t:        t,
+		ln:       ln,
+		url:      "ldap://" + ln.Addr().String(),
+		password: "123456",
+		conns:    make(map[net.Conn]struct{}),
+		requests: make(map[ber.Tag]int),
+	}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// config returns a pool configuration pointing at the fake server
+func (s *fakeServer) config() LdapConfig {
+	config := getTestConfig()
+	config.Url = s.url
+	config.ConnTimeout = 2 * time.Second
+	return config
+}
+
+// Close stops accepting connections and drops every live connection
+func (s *fakeServer) Close() {
+	s.ln.Close()
+	s.dropAll()
+}
+
+// dropAll closes every live server-side connection
+func (s *fakeServer) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// setPassword changes the password accepted by subsequent binds
+func (s *fakeServer) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// record counts a request and, for binds, reports whether the password is
+// accepted. Both happen under one lock so a test that has seen the bind
+// counted knows the password was already checked.
+func (s *fakeServer) record(op *ber.Packet) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[op.Tag]++
+	return len(op.Children) >= 3 && op.Children[2].Data.String() == s.password
+}
+
+// waitIdle blocks until the server has no open connections
+func (s *fakeServer) waitIdle() {
+	s.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Live() > 0 {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("Server still has %d open connections", s.Live())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Requests returns how many requests of the given operation were received
+func (s *fakeServer) Requests(tag ber.Tag) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[tag]
+}
+
+// setHang makes the server silently drop search requests, like a peer behind
+// a firewall that has forgotten the connection
+func (s *fakeServer) setHang(hang bool) {
+	var v int32
+	if hang {
+		v = 1
+	}
+	atomic.StoreInt32(&s.hang, v)
+}
+
+// setEntries makes searches return n entries below the base DN instead of
+// the base entry, paged when the request asks for it
+func (s *fakeServer) setEntries(n int) {
+	atomic.StoreInt32(&s.entries, int32(n))
+}
+
+// dropRequests makes the server close the connection instead of answering
+// the next n requests other than binds, like a peer that restarted
+func (s *fakeServer) dropRequests(n int) {
+	atomic.StoreInt32(&s.dropNext, int32(n))
+}
+
+// Live returns the number of currently open server-side connections
+func (s *fakeServer) Live() int {
+	return int(atomic.LoadInt32(&s.live))
+}
+
+// MaxLive returns the highest number of simultaneously open connections seen
+func (s *fakeServer) MaxLive() int {
+	return int(atomic.LoadInt32(&s.maxLive))
+}
+
+// Accepted returns the total number of accepted connections
+func (s *fakeServer) Accepted() int {
+	return int(atomic.LoadInt32(&s.accepted))
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		atomic.AddInt32(&s.accepted, 1)
+		live := atomic.AddInt32(&s.live, 1)
+		for {
+			max := atomic.LoadInt32(&s.maxLive)
+			if live <= max || atomic.CompareAndSwapInt32(&s.maxLive, max, live) {
+				break
+			}
+		}
+
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		atomic.AddInt32(&s.live, -1)
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	var wmu sync.Mutex
+	write := func(p *ber.Packet) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		c.Write(p.Bytes())
+	}
+
+	for {
+		packet, err := ber.ReadPacket(r)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		msgID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		accepted := s.record(op)
+
+		if op.Tag != ldap.ApplicationBindRequest && atomic.LoadInt32(&s.dropNext) > 0 &&
+			atomic.AddInt32(&s.dropNext, -1) >= 0 {
+			return
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := uint16(ldap.LDAPResultSuccess)
+			if !accepted {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			if s.bindDelay > 0 {
+				time.Sleep(s.bindDelay)
+			}
+			write(ldapResponse(msgID, ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			if atomic.LoadInt32(&s.hang) == 1 {
+				continue
+			}
+			baseDN, _ := op.Children[0].Value.(string)
+			if n := int(atomic.LoadInt32(&s.entries)); n > 0 {
+				writeEntries(write, msgID, baseDN, packet, n)
+				continue
+			}
+			write(searchEntry(msgID, baseDN))
+			write(ldapResponse(msgID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationAddRequest:
+			write(ldapResponse(msgID, ldap.ApplicationAddResponse, ldap.LDAPResultSuccess))
+		case ldap.ApplicationModifyRequest:
+			write(ldapResponse(msgID, ldap.ApplicationModifyResponse, ldap.LDAPResultSuccess))
+		case ldap.ApplicationDelRequest:
+			write(ldapResponse(msgID, ldap.ApplicationDelResponse, ldap.LDAPResultSuccess))
+		case ldap.ApplicationModifyDNRequest:
+			write(ldapResponse(msgID, ldap.ApplicationModifyDNResponse, ldap.LDAPResultSuccess))
+		case ldap.ApplicationCompareRequest:
+			write(ldapResponse(msgID, ldap.ApplicationCompareResponse, ldap.LDAPResultCompareTrue))
+		}
+	}
+}
+
+// ldapResponse encodes an LDAPResult style response
+func ldapResponse(msgID int64, tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "MessageID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	packet.AppendChild(result)
+	return packet
+}
+
+// writeEntries answers a search with n entries below baseDN. A request with
+// the paging control gets one page, the cookie being the next entry's index.
+func writeEntries(write func(*ber.Packet), msgID int64, baseDN string, request *ber.Packet, n int) {
+	var paging *ldap.ControlPaging
+	if len(request.Children) > 2 {
+		for _, child := range request.Children[2].Children {
+			if control, err := ldap.DecodeControl(child); err == nil {
+				if p, ok := control.(*ldap.ControlPaging); ok {
+					paging = p
+				}
+			}
+		}
+	}
+
+	start, end := 0, n
+	if paging != nil {
+		start, _ = strconv.Atoi(string(paging.Cookie))
+		end = min(start+int(paging.PagingSize), n)
+	}
+	for i := start; i < end; i++ {
+		write(searchEntry(msgID, fmt.Sprintf("cn=user%d,%s", i, baseDN)))
+	}
+
+	done := ldapResponse(msgID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+	if paging != nil {
+		next := ldap.NewControlPaging(paging.PagingSize)
+		if end < n && paging.PagingSize > 0 {
+			next.SetCookie([]byte(strconv.Itoa(end)))
+		}
+		controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		controls.AppendChild(next.Encode())
+		done.AppendChild(controls)
+	}
+	write(done)
+}
+
+// searchEntry encodes a single search result entry
+func searchEntry(msgID int64, dn string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "MessageID"))
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+	attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "objectClass", "type"))
+	vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+	vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "top", "value"))
+	attr.AppendChild(vals)
+	attrs.AppendChild(attr)
+	entry.AppendChild(attrs)
+	packet.AppendChild(entry)
+	return packet
+}
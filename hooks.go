@@ -0,0 +1,46 @@
+package ldapool
+
+import (
+	"context"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Hooks run custom code at points in a connection's lifecycle, eg. to set
+// per-connection timeouts, record audit data or reject a connection. Hooks are
+// called synchronously; OnRelease and OnEvict may be called with the pool's
+// lock held and must not call back into the pool.
+type Hooks interface {
+	// OnDial is called once a connection to server is established, including
+	// StartTLS, before it is bound. An error discards the connection.
+	OnDial(ctx context.Context, server string, conn *ldap.Conn) error
+	// OnBind is called after the connection to server is bound with the admin
+	// credentials. An error discards the connection.
+	OnBind(ctx context.Context, server string, conn *ldap.Conn) error
+	// OnAcquire is called before GetConnection hands conn out. An error
+	// discards conn and GetConnection tries the next one.
+	OnAcquire(ctx context.Context, conn *LdapConn) error
+	// OnRelease is called when a borrowed connection is returned to the pool
+	OnRelease(conn *LdapConn)
+	// OnEvict is called when the pool closes conn. reason is one of
+	// "broken", "max_lifetime", "max_idle_time", "max_idle", "drained",
+	// "rejected", "pool_closed", "leaked" or "forced".
+	OnEvict(conn *LdapConn, reason string)
+}
+
+// NopHooks implements Hooks with methods that do nothing. Embed it to
+// implement only the hooks you need.
+type NopHooks struct{}
+
+func (NopHooks) OnDial(context.Context, string, *ldap.Conn) error { return nil }
+func (NopHooks) OnBind(context.Context, string, *ldap.Conn) error { return nil }
+func (NopHooks) OnAcquire(context.Context, *LdapConn) error       { return nil }
+func (NopHooks) OnRelease(*LdapConn)                              {}
+func (NopHooks) OnEvict(*LdapConn, string)                        {}
+
+// evict notifies the OnEvict hook that conn is being closed for reason
+func (lcp *LdapConnPool) evict(conn *LdapConn, reason closeReason) {
+	if lcp.config.Hooks != nil {
+		lcp.config.Hooks.OnEvict(conn, reason.String())
+	}
+}
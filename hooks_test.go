@@ -0,0 +1,174 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// recordingHooks records the hooks it is called with and rejects the
+// connections listed in reject on acquire
+type recordingHooks struct {
+	mu      sync.Mutex
+	events  []string
+	evicted []string
+	reject  map[*LdapConn]bool
+	dialErr error
+}
+
+func (h *recordingHooks) record(event string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+}
+
+func (h *recordingHooks) OnDial(ctx context.Context, server string, conn *ldap.Conn) error {
+	h.record("dial")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dialErr
+}
+
+func (h *recordingHooks) OnBind(ctx context.Context, server string, conn *ldap.Conn) error {
+	h.record("bind")
+	conn.SetTimeout(time.Second)
+	return nil
+}
+
+func (h *recordingHooks) OnAcquire(ctx context.Context, conn *LdapConn) error {
+	h.record("acquire")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reject[conn] {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (h *recordingHooks) OnRelease(conn *LdapConn) {
+	h.record("release")
+}
+
+func (h *recordingHooks) OnEvict(conn *LdapConn, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.evicted = append(h.evicted, reason)
+}
+
+func TestHooks(t *testing.T) {
+	server := newFakeServer(t)
+	hooks := &recordingHooks{reject: make(map[*LdapConn]bool)}
+	config := server.config()
+	config.LazyConnect = true
+	config.Hooks = hooks
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	conn.Close()
+
+	hooks.mu.Lock()
+	got := append([]string(nil), hooks.events...)
+	hooks.mu.Unlock()
+	want := []string{"dial", "bind", "acquire", "release"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected hooks %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected hooks %v, got %v", want, got)
+		}
+	}
+
+	// The idle connection is rejected, so a new one is dialed
+	hooks.mu.Lock()
+	hooks.reject[conn.pooled] = true
+	hooks.mu.Unlock()
+	next, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if next.pooled == conn.pooled {
+		t.Error("Expected the rejected connection to be discarded")
+	}
+	if server.Accepted() != 2 {
+		t.Errorf("Expected a second dial, got %d", server.Accepted())
+	}
+	next.Close()
+
+	hooks.mu.Lock()
+	if len(hooks.evicted) != 1 || hooks.evicted[0] != "rejected" {
+		t.Errorf("Expected one rejected eviction, got %v", hooks.evicted)
+	}
+	hooks.mu.Unlock()
+
+	pool.Close()
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if len(hooks.evicted) != 2 || hooks.evicted[1] != "pool_closed" {
+		t.Errorf("Expected idle connection evicted on close, got %v", hooks.evicted)
+	}
+}
+
+func TestHooksReject(t *testing.T) {
+	server := newFakeServer(t)
+	hooks := &rejectAllHooks{}
+	config := server.config()
+	config.LazyConnect = true
+	config.MaxOpen = 2
+	config.Hooks = hooks
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	// Every connection is rejected: give up after MaxOpen+1 attempts
+	if _, err := pool.GetConnection(context.Background()); !errors.Is(err, errRejectAll) {
+		t.Fatalf("Expected hook error, got %v", err)
+	}
+	if server.Accepted() != 3 {
+		t.Errorf("Expected 3 dials, got %d", server.Accepted())
+	}
+	if stats := pool.PoolStats(); stats.Open != 0 {
+		t.Errorf("Expected rejected connections to free their slots, got %d open", stats.Open)
+	}
+
+	// A rejected dial fails GetConnection
+	hooks.dial = true
+	if _, err := pool.GetConnection(context.Background()); !errors.Is(err, errRejectAll) {
+		t.Fatalf("Expected dial hook error, got %v", err)
+	}
+	if n := server.Requests(ldap.ApplicationBindRequest); n != 3 {
+		t.Errorf("Expected no bind after a rejected dial, got %d binds", n)
+	}
+}
+
+var errRejectAll = errors.New("rejected")
+
+// rejectAllHooks rejects every acquire, and every dial once dial is set
+type rejectAllHooks struct {
+	NopHooks
+	dial bool
+}
+
+func (h *rejectAllHooks) OnDial(ctx context.Context, server string, conn *ldap.Conn) error {
+	if h.dial {
+		return errRejectAll
+	}
+	return nil
+}
+
+func (h *rejectAllHooks) OnAcquire(ctx context.Context, conn *LdapConn) error {
+	return errRejectAll
+}
@@ -5,13 +5,16 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
+	"log/slog"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -19,12 +22,31 @@ var (
 	ErrConnClosed    = errors.New("connection is closed")
 	ErrInvalidConfig = errors.New("invalid LDAP configuration")
 	ErrTimeout       = errors.New("operation timeout")
+	ErrCircuitOpen   = errors.New("circuit breaker is open")
+	ErrNoServers     = errors.New("no LDAP server available")
+	ErrStartTLS      = errors.New("StartTLS on a pooled connection, use UseStartTLS instead")
 )
 
 // LdapConfig ldap conn config
 type LdapConfig struct {
+	// pool name reported in traces and metrics
+	Name string
 	// ldap server url. eg: ldap://localhost:389, ldaps://localhost:636
 	Url string
+	// ldap server urls, tried according to ServerStrategy. Takes precedence over Url.
+	Urls []string
+	// how the server for a new connection is picked from Urls
+	ServerStrategy ServerStrategy
+	// how long a server that failed to connect is kept out of rotation
+	ServerBackoff time.Duration
+	// domain whose SRV records list the servers, used instead of Url and Urls. eg: eryajf.net
+	SRVDomain string
+	// SRV service name, "ldap" by default; "ldaps" dials the servers with TLS
+	SRVService string
+	// how often the SRV records are resolved again
+	SRVRefreshInterval time.Duration
+	// resolver for SRV lookups, net.DefaultResolver when nil
+	Resolver SRVResolver
 	// ldap server base DN. eg: dc=eryajf,dc=net
 	BaseDN string
 	// ldap server admin DN. eg: cn=admin,dc=eryajf,dc=net
@@ -35,18 +57,48 @@ type LdapConfig struct {
 	MaxOpen int
 	// maximum number of idle connections
 	MaxIdle int
+	// minimum number of idle connections kept ready, capped at MaxIdle
+	MinIdle int
+	// open the MinIdle connections in the background instead of blocking NewPool
+	AsyncWarmup bool
+	// create the pool without connecting; check reachability with Ping instead
+	LazyConnect bool
 	// maximum lifetime of connections
 	ConnMaxLifetime time.Duration
 	// maximum idle time for connections
 	ConnMaxIdleTime time.Duration
 	// connection timeout
 	ConnTimeout time.Duration
+	// consecutive dial or bind failures that open the circuit breaker, 0 disables it
+	BreakerThreshold int
+	// how long the circuit breaker first stays open, doubled on every failed trial
+	BreakerBackoff time.Duration
+	// upper bound for the circuit breaker backoff
+	BreakerMaxBackoff time.Duration
+	// interval between liveness probes of idle connections, 0 disables probing
+	HealthCheckInterval time.Duration
+	// probe idle connections before handing them out of GetConnection
+	ValidateOnBorrow bool
+	// report connections borrowed for longer than this, 0 disables leak detection
+	LeakThreshold time.Duration
+	// close leaked connections and free their slots instead of only reporting them
+	ReclaimLeaked bool
+	// how the pool level operations (Search, Add, ...) retry on stale connections
+	Retry RetryPolicy
 	// TLS configuration for secure connections
 	TLSConfig *tls.Config
 	// Use StartTLS for upgrading plain LDAP connections to TLS
 	UseStartTLS bool
 	// Skip TLS certificate verification (not recommended for production)
 	InsecureSkipVerify bool
+	// OpenTelemetry tracer provider, tracing is off when nil
+	TracerProvider trace.TracerProvider
+	// OpenTelemetry meter provider for the pool gauges, metrics are off when nil
+	MeterProvider metric.MeterProvider
+	// structured logger for pool events, the pool is silent when nil
+	Logger *slog.Logger
+	// custom code run at points in the connection lifecycle
+	Hooks Hooks
 }
 
 // LdapConn wraps ldap.Conn with additional metadata
@@ -54,18 +106,84 @@ type LdapConn struct {
 	*ldap.Conn
 	createdAt time.Time
 	lastUsed  time.Time
+	server    string
 	pool      *LdapConnPool
+	// ctx is the context the connection was borrowed with, parenting the
+	// spans of its operations
+	ctx context.Context
+	// pooled is the pool's connection a handle returned by GetConnection
+	// was borrowed from, nil for the pool's own connections
+	pooled *LdapConn
+	// returned is set once a handle has been given back to the pool
+	returned int32
+	// onReturn, if set, is called once the handle has been given back
+	onReturn func()
+	// wire is the network connection under Conn, used to abandon requests
+	wire *wireConn
+	// suspect is set when a request was abandoned, and closed once the
+	// abandoned operation has finished. Guarded by the pool's mu.
+	suspect chan struct{}
+
+	// leak detection state, guarded by the pool's mu
+	checkedOutAt  time.Time
+	checkoutStack []byte
+	leakReported  bool
+}
+
+// Server returns the url of the server the connection was dialed to
+func (lc *LdapConn) Server() string {
+	return lc.server
 }
 
-// Close returns the connection to the pool
+// Close returns the connection to the pool. Closing it again returns
+// ErrConnClosed.
 func (lc *LdapConn) Close() error {
 	if lc.pool != nil {
-		lc.pool.PutConnection(lc)
-		return nil
+		return lc.pool.release(lc)
 	}
 	return lc.Conn.Close()
 }
 
+// borrow returns a new handle to conn for a caller of GetConnection, so a
+// stale handle can never reach the connection once it is lent out again
+func (lc *LdapConn) borrow(ctx context.Context) *LdapConn {
+	return &LdapConn{
+		Conn:      lc.Conn,
+		createdAt: lc.createdAt,
+		lastUsed:  lc.lastUsed,
+		server:    lc.server,
+		pool:      lc.pool,
+		ctx:       ctx,
+		pooled:    lc,
+		wire:      lc.wire,
+	}
+}
+
+// usable returns ErrConnClosed once the handle has been returned to the pool
+func (lc *LdapConn) usable() error {
+	if atomic.LoadInt32(&lc.returned) == 1 {
+		return ErrConnClosed
+	}
+	return nil
+}
+
+// rootDSERequest is the cheap base search used to probe connection liveness
+var rootDSERequest = ldap.NewSearchRequest(
+	"", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+	0, 0, false, "(objectClass=*)", []string{"1.1"}, nil,
+)
+
+// ping probes the connection with a RootDSE search. A server that does not
+// answer within timeout is treated as dead and the connection is closed.
+func (lc *LdapConn) ping(timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() { lc.Conn.Close() })
+	_, err := lc.Conn.Search(rootDSERequest)
+	if !timer.Stop() {
+		return ErrTimeout
+	}
+	return err
+}
+
 // IsExpired checks if connection has exceeded max lifetime or idle time
 func (lc *LdapConn) IsExpired(maxLifetime, maxIdleTime time.Duration) bool {
 	now := time.Now()
@@ -79,20 +197,54 @@ func (lc *LdapConn) IsExpired(maxLifetime, maxIdleTime time.Duration) bool {
 }
 
 var (
-	defaultPool     *LdapConnPool
-	defaultInitOnce sync.Once
+	defaultMu sync.Mutex
+	// defaultEntry is the default pool, published before it has been created
+	// so its creation does not hold defaultMu
+	defaultEntry *registryEntry
 )
 
 // LdapConnPool represents a pool of LDAP connections
 type LdapConnPool struct {
 	mu          sync.Mutex
 	config      LdapConfig
+	servers     *serverList
+	breaker     *breaker
 	conns       []*LdapConn
-	reqConns    map[uint64]chan *LdapConn
+	waiters     waitQueue
 	openConn    int32
 	closed      int32
 	cleanupOnce sync.Once
 	stopCleanup chan struct{}
+	warming     int32
+	warmed      int32
+	healthMu    sync.Mutex
+	healthy     bool
+	lastErr     error
+	lastErrAt   time.Time
+	observerMu  sync.RWMutex
+	observers   []Observer
+	tracer      trace.Tracer
+	metrics     metric.Registration
+	logger      *slog.Logger
+	borrowed    map[*LdapConn]struct{}
+	drained     chan struct{}
+
+	// lifetime counters reported by PoolStats
+	dials             int64
+	waitCount         int64
+	waitDuration      int64
+	maxIdleClosed     int64
+	maxIdleTimeClosed int64
+	maxLifetimeClosed int64
+	brokenClosed      int64
+	dialFailures      int64
+	bindFailures      int64
+	leakedClosed      int64
+	drainedClosed     int64
+	rejectedClosed    int64
+	shutdownClosed    int64
+	forcedClosed      int64
+	abandoned         int64
 }
 
 // NewPool creates a new LDAP connection pool
@@ -103,50 +255,169 @@ func NewPool(config LdapConfig) (*LdapConnPool, error) {
 
 	setDefaults(&config)
 
+	servers := newServerList(nil, config.ServerStrategy, config.ServerBackoff)
+	var discoverErr error
+	if config.SRVDomain == "" {
+		servers.set(config.serverURLs())
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), config.ConnTimeout)
+		var discovered [][]string
+		discovered, discoverErr = discoverServers(ctx, config)
+		cancel()
+		if discoverErr != nil && !config.LazyConnect {
+			return nil, discoverErr
+		}
+		// A lazy pool retries discovery on its first dial
+		servers.setGroups(discovered)
+	}
+
 	pool := &LdapConnPool{
 		config:      config,
+		servers:     servers,
+		breaker:     newBreaker(config.BreakerThreshold, config.BreakerBackoff, config.BreakerMaxBackoff),
 		conns:       make([]*LdapConn, 0),
-		reqConns:    make(map[uint64]chan *LdapConn),
 		stopCleanup: make(chan struct{}),
+		tracer:      newTracer(config),
+		logger:      newLogger(config),
+		borrowed:    make(map[*LdapConn]struct{}),
+	}
+	if discoverErr != nil {
+		pool.recordHealth(discoverErr)
 	}
 
 	// Test connection
-	testConn, err := pool.createConnection()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create test connection: %w", err)
+	if !config.LazyConnect {
+		testConn, err := pool.createConnection(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create test connection: %w", err)
+		}
+		if config.MinIdle > 0 {
+			// Keep the test connection as the first warm connection
+			atomic.AddInt32(&pool.openConn, 1)
+			pool.conns = append(pool.conns, testConn)
+			pool.warmed = 1
+		} else {
+			testConn.Conn.Close()
+		}
+	}
+
+	// Fill up to MinIdle
+	pool.warming = 1
+	if config.AsyncWarmup || config.LazyConnect {
+		go pool.warmup()
+	} else if err := pool.warmup(); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to warm up pool: %w", err)
+	}
+
+	if err := pool.registerMetrics(); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
 	}
-	testConn.Conn.Close()
 
 	// Start cleanup goroutine
 	go pool.cleanup()
 
+	pool.logger.Info("ldap pool created", slog.Any("config", config))
 	return pool, nil
 }
 
-// InitDefault initializes the default global pool
+// InitDefault initializes the default global pool. It does nothing if the
+// default pool already exists; a failed initialization can be retried.
 func InitDefault(config LdapConfig) error {
-	var initErr error
-	defaultInitOnce.Do(func() {
-		pool, err := NewPool(config)
-		if err != nil {
-			initErr = err
-			return
+	_, err := initDefault(config)
+	return err
+}
+
+// initDefault returns the default pool, creating it from config if needed
+func initDefault(config LdapConfig) (*LdapConnPool, error) {
+	defaultMu.Lock()
+	if entry := defaultEntry; entry != nil {
+		defaultMu.Unlock()
+		if err := entry.wait(); err != nil {
+			return nil, err
 		}
-		defaultPool = pool
-	})
-	return initErr
+		return entry.pool, nil
+	}
+	entry := &registryEntry{config: config, ready: make(chan struct{})}
+	defaultEntry = entry
+	defaultMu.Unlock()
+
+	pool, err := NewPool(config)
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	entry.pool, entry.err = pool, err
+	// Drop a failed entry so the initialization can be retried
+	if err != nil && defaultEntry == entry {
+		defaultEntry = nil
+	}
+	close(entry.ready)
+	return pool, err
 }
 
-// GetDefault returns the default pool
+// GetDefault returns the default pool, or nil if it is not initialized yet
 func GetDefault() *LdapConnPool {
-	return defaultPool
+	defaultMu.Lock()
+	entry := defaultEntry
+	defaultMu.Unlock()
+	if entry == nil {
+		return nil
+	}
+	select {
+	case <-entry.ready:
+		return entry.pool
+	default:
+		return nil
+	}
+}
+
+// ResetDefault replaces the default pool with a new one created from config.
+// The previous pool is closed only once the new one is ready, so a failed
+// reset leaves the current default pool in place.
+func ResetDefault(config LdapConfig) error {
+	pool, err := NewPool(config)
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan struct{})
+	close(ready)
+	defaultMu.Lock()
+	old := defaultEntry
+	defaultEntry = &registryEntry{config: config, pool: pool, ready: ready}
+	defaultMu.Unlock()
+
+	if old != nil && old.wait() == nil {
+		old.pool.Close()
+	}
+	return nil
+}
+
+// CloseDefault closes the default pool. A later InitDefault or Open creates
+// a new one.
+func CloseDefault() error {
+	defaultMu.Lock()
+	entry := defaultEntry
+	defaultEntry = nil
+	defaultMu.Unlock()
+
+	if entry == nil || entry.wait() != nil {
+		return nil
+	}
+	return entry.pool.Close()
 }
 
 // validateConfig validates the LDAP configuration
 func validateConfig(config LdapConfig) error {
-	if config.Url == "" {
+	if config.Url == "" && len(config.Urls) == 0 && config.SRVDomain == "" {
 		return fmt.Errorf("%w: URL is required", ErrInvalidConfig)
 	}
+	for _, url := range config.Urls {
+		if url == "" {
+			return fmt.Errorf("%w: empty server URL", ErrInvalidConfig)
+		}
+	}
 	if config.AdminDN == "" {
 		return fmt.Errorf("%w: AdminDN is required", ErrInvalidConfig)
 	}
@@ -164,6 +435,12 @@ func setDefaults(config *LdapConfig) {
 	if config.MaxIdle <= 0 {
 		config.MaxIdle = 5
 	}
+	if config.MinIdle > config.MaxIdle {
+		config.MinIdle = config.MaxIdle
+	}
+	if config.MinIdle > config.MaxOpen {
+		config.MinIdle = config.MaxOpen
+	}
 	if config.ConnTimeout <= 0 {
 		config.ConnTimeout = 30 * time.Second
 	}
@@ -173,16 +450,44 @@ func setDefaults(config *LdapConfig) {
 	if config.ConnMaxIdleTime <= 0 {
 		config.ConnMaxIdleTime = 30 * time.Minute
 	}
+	if config.ServerBackoff <= 0 {
+		config.ServerBackoff = 30 * time.Second
+	}
+	if config.BreakerBackoff <= 0 {
+		config.BreakerBackoff = time.Second
+	}
+	if config.BreakerMaxBackoff <= 0 {
+		config.BreakerMaxBackoff = time.Minute
+	}
+	if config.BreakerMaxBackoff < config.BreakerBackoff {
+		config.BreakerMaxBackoff = config.BreakerBackoff
+	}
+	if config.SRVService == "" {
+		config.SRVService = "ldap"
+	}
+	if config.SRVRefreshInterval <= 0 {
+		config.SRVRefreshInterval = 5 * time.Minute
+	}
+	if config.Retry.MaxRetries == 0 {
+		config.Retry.MaxRetries = 2
+	}
+}
+
+// serverURLs returns the configured servers, in order of preference
+func (config LdapConfig) serverURLs() []string {
+	if len(config.Urls) > 0 {
+		return config.Urls
+	}
+	return []string{config.Url}
 }
 
 // Open gets a connection from the default pool (for backwards compatibility)
 func Open(conf LdapConfig) (*LdapConn, error) {
-	if defaultPool == nil {
-		if err := InitDefault(conf); err != nil {
-			return nil, err
-		}
+	pool, err := initDefault(conf)
+	if err != nil {
+		return nil, err
 	}
-	return defaultPool.GetConnection(context.Background())
+	return pool.GetConnection(context.Background())
 }
 
 // GetLDAPConn gets a connection from the default pool (for backwards compatibility)
@@ -197,13 +502,61 @@ func PutLADPConn(conn *LdapConn) {
 	}
 }
 
-// GetConnection gets a connection from the pool
+// GetConnection gets a connection from the pool. When the pool is saturated
+// the caller waits in line and is served in FIFO order; contexts created with
+// WithPriority are served ahead of everyone else.
 func (lcp *LdapConnPool) GetConnection(ctx context.Context) (*LdapConn, error) {
+	start := time.Now()
+	spanCtx, span := lcp.startSpan(ctx, "ldapool.acquire")
+	conn, err := lcp.acquire(spanCtx)
+	endSpan(span, err)
+	lcp.observeAcquire(start, err)
+	if conn == nil {
+		return nil, err
+	}
+	handle := conn.borrow(ctx)
+	lcp.trackCheckout(handle)
+	return handle, nil
+}
+
+// acquire gets a connection accepted by the OnAcquire hook. Rejected
+// connections are discarded; after MaxOpen+1 rejections, enough to have gone
+// through every pooled connection and dialed a new one, the hook's error is
+// returned.
+func (lcp *LdapConnPool) acquire(ctx context.Context) (*LdapConn, error) {
+	if lcp.config.Hooks == nil {
+		return lcp.getConnection(ctx)
+	}
+	var err error
+	for range lcp.config.MaxOpen + 1 {
+		var conn *LdapConn
+		conn, err = lcp.getConnection(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn.ctx = ctx
+		if err = lcp.config.Hooks.OnAcquire(ctx, conn); err == nil {
+			return conn, nil
+		}
+		lcp.mu.Lock()
+		lcp.closeConnLocked(conn, closeRejected)
+		lcp.mu.Unlock()
+	}
+	return nil, fmt.Errorf("connection rejected by OnAcquire hook: %w", err)
+}
+
+// getConnection gets a connection from the pool, without running hooks
+func (lcp *LdapConnPool) getConnection(ctx context.Context) (*LdapConn, error) {
 	if atomic.LoadInt32(&lcp.closed) == 1 {
 		return nil, ErrPoolClosed
 	}
 
 	lcp.mu.Lock()
+	// Close may have run since the check above, leaving nobody to wake a waiter
+	if atomic.LoadInt32(&lcp.closed) == 1 {
+		lcp.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
 
 	// Try to get an existing connection
 	for len(lcp.conns) > 0 {
@@ -211,173 +564,604 @@ func (lcp *LdapConnPool) GetConnection(ctx context.Context) (*LdapConn, error) {
 		lcp.conns = lcp.conns[:len(lcp.conns)-1]
 
 		// Check if connection is still valid
-		if !conn.IsClosing() && !conn.IsExpired(lcp.config.ConnMaxLifetime, lcp.config.ConnMaxIdleTime) {
-			conn.lastUsed = time.Now()
+		reason := lcp.closeReason(conn)
+		if reason == closeNone {
+			if !lcp.config.ValidateOnBorrow {
+				conn.lastUsed = time.Now()
+				lcp.mu.Unlock()
+				return conn, nil
+			}
+
+			// Probe without holding the lock; the slot stays reserved meanwhile
 			lcp.mu.Unlock()
-			return conn, nil
+			err := conn.ping(lcp.config.ConnTimeout)
+			lcp.mu.Lock()
+			if atomic.LoadInt32(&lcp.closed) == 1 {
+				lcp.closeConnLocked(conn, closePoolClosed)
+				lcp.mu.Unlock()
+				return nil, ErrPoolClosed
+			}
+			if err == nil {
+				conn.lastUsed = time.Now()
+				lcp.mu.Unlock()
+				return conn, nil
+			}
+			reason = closeBroken
 		}
 
 		// Connection is invalid, close it
-		conn.Conn.Close()
-		atomic.AddInt32(&lcp.openConn, -1)
+		lcp.closeConnLocked(conn, reason)
 	}
 
 	// Check if we can create a new connection
 	currentOpen := atomic.LoadInt32(&lcp.openConn)
 	if currentOpen >= int32(lcp.config.MaxOpen) {
 		// Need to wait for a connection
-		req := make(chan *LdapConn, 1)
-		reqKey := lcp.nextRequestKeyLocked()
-		lcp.reqConns[reqKey] = req
+		req := &connRequest{ch: make(chan connResult, 1), ctx: ctx}
+		lcp.waiters.push(req, isPriority(ctx))
 		lcp.mu.Unlock()
+		trace.SpanFromContext(ctx).SetAttributes(attrWaited.Bool(true))
+
+		start := time.Now()
+		defer func() {
+			atomic.AddInt64(&lcp.waitCount, 1)
+			atomic.AddInt64(&lcp.waitDuration, int64(time.Since(start)))
+		}()
 
 		select {
-		case conn := <-req:
-			return conn, nil
+		case res := <-req.ch:
+			return res.conn, res.err
 		case <-ctx.Done():
 			// Remove from queue
 			lcp.mu.Lock()
-			delete(lcp.reqConns, reqKey)
+			queued := lcp.waiters.remove(req)
 			lcp.mu.Unlock()
+
+			// A connection may already be on its way to us; put it back once it arrives
+			if !queued {
+				go func() {
+					if res := <-req.ch; res.conn != nil {
+						lcp.putConnection(res.conn, time.Now())
+					}
+				}()
+			}
+			lcp.logger.Warn("ldap connection wait aborted",
+				slog.Duration("waited", time.Since(start)), slog.Any("error", ctx.Err()))
 			return nil, ctx.Err()
 		}
 	}
 
-	// We can create a new connection
+	if err := ctx.Err(); err != nil {
+		lcp.mu.Unlock()
+		return nil, err
+	}
+
+	// Reserve a slot before dialing so concurrent callers cannot overshoot MaxOpen
+	atomic.AddInt32(&lcp.openConn, 1)
 	lcp.mu.Unlock()
-	return lcp.createConnection()
+
+	conn, err := lcp.createConnection(ctx)
+	if err != nil {
+		// Give the reserved slot back
+		lcp.mu.Lock()
+		lcp.releaseSlotLocked()
+		lcp.mu.Unlock()
+		return nil, err
+	}
+	return conn, nil
 }
 
-// PutConnection returns a connection to the pool
+// PutConnection returns a connection to the pool. Returning a connection
+// again is a no-op.
 func (lcp *LdapConnPool) PutConnection(conn *LdapConn) {
-	if conn == nil || atomic.LoadInt32(&lcp.closed) == 1 {
-		if conn != nil {
-			conn.Conn.Close()
-			atomic.AddInt32(&lcp.openConn, -1)
+	lcp.release(conn)
+}
+
+// release returns a handle borrowed from GetConnection to the pool, failing
+// with ErrConnClosed if it was already returned. A connection that had a
+// request abandoned is validated in the background before it is reused.
+func (lcp *LdapConnPool) release(conn *LdapConn) error {
+	if conn == nil {
+		return nil
+	}
+	pooled := conn
+	if conn.pooled != nil {
+		if !atomic.CompareAndSwapInt32(&conn.returned, 0, 1) {
+			return ErrConnClosed
 		}
-		return
+		pooled = conn.pooled
+		lcp.untrackCheckout(conn)
+		if conn.onReturn != nil {
+			conn.onReturn()
+		}
+	}
+	if lcp.config.Hooks != nil {
+		lcp.config.Hooks.OnRelease(pooled)
 	}
+	if done := lcp.takeSuspect(pooled); done != nil {
+		go lcp.revalidate(pooled, done)
+		return nil
+	}
+	lcp.putConnection(pooled, time.Now())
+	return nil
+}
 
+// discard closes a handle borrowed from GetConnection instead of returning it
+// to the pool, for connections left in an unknown state
+func (lcp *LdapConnPool) discard(conn *LdapConn) {
+	if !atomic.CompareAndSwapInt32(&conn.returned, 0, 1) {
+		return
+	}
+	lcp.untrackCheckout(conn)
+	if conn.onReturn != nil {
+		conn.onReturn()
+	}
+	if lcp.config.Hooks != nil {
+		lcp.config.Hooks.OnRelease(conn.pooled)
+	}
 	lcp.mu.Lock()
 	defer lcp.mu.Unlock()
+	lcp.closeConnLocked(conn.pooled, closeBroken)
+}
 
-	// Check if there are waiting requests
-	if len(lcp.reqConns) > 0 {
-		var req chan *LdapConn
-		var reqKey uint64
-		for reqKey, req = range lcp.reqConns {
-			break
+// WithConn borrows a connection, runs fn with it and returns it to the pool.
+// The connection is discarded instead when fn fails with a network error or
+// panics; a panic is recovered and returned as an error.
+func (lcp *LdapConnPool) WithConn(ctx context.Context, fn func(*LdapConn) error) (err error) {
+	conn, err := lcp.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			lcp.discard(conn)
+			if perr, ok := r.(error); ok {
+				err = fmt.Errorf("panic in WithConn callback: %w", perr)
+			} else {
+				err = fmt.Errorf("panic in WithConn callback: %v", r)
+			}
 		}
-		delete(lcp.reqConns, reqKey)
+	}()
+
+	err = fn(conn)
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		lcp.discard(conn)
+	} else {
+		conn.Close()
+	}
+	return err
+}
+
+// putConnection returns a connection to the pool, recording lastUsed as the
+// time it was last used by a caller
+func (lcp *LdapConnPool) putConnection(conn *LdapConn, lastUsed time.Time) {
+	if conn == nil {
+		return
+	}
+	conn.ctx = nil
+
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+
+	if atomic.LoadInt32(&lcp.closed) == 1 {
+		lcp.closeConnLocked(conn, closePoolClosed)
+		return
+	}
+
+	// Broken, expired or drained connections are never handed out again
+	if reason := lcp.closeReason(conn); reason != closeNone {
+		lcp.closeConnLocked(conn, reason)
+		return
+	}
 
+	// Hand the connection to the longest waiting request
+	if req := lcp.waiters.pop(); req != nil {
 		// Update last used time
-		conn.lastUsed = time.Now()
-		req <- conn
+		conn.lastUsed = lastUsed
+		req.ch <- connResult{conn: conn}
 		return
 	}
 
 	// Check if connection should be kept in pool
-	if len(lcp.conns) < lcp.config.MaxIdle &&
-		!conn.IsClosing() &&
-		!conn.IsExpired(lcp.config.ConnMaxLifetime, lcp.config.ConnMaxIdleTime) {
-		conn.lastUsed = time.Now()
+	if len(lcp.conns) < lcp.config.MaxIdle {
+		conn.lastUsed = lastUsed
 		lcp.conns = append(lcp.conns, conn)
 		return
 	}
 
 	// Close the connection
+	lcp.closeConnLocked(conn, closeMaxIdle)
+}
+
+// closeReason says why a connection is closed, for PoolStats
+type closeReason int
+
+const (
+	// closeNone means the connection is still usable
+	closeNone closeReason = iota
+	// closeBroken means the connection failed or was closed by the server
+	closeBroken
+	// closeMaxLifetime means the connection exceeded ConnMaxLifetime
+	closeMaxLifetime
+	// closeMaxIdleTime means the connection exceeded ConnMaxIdleTime
+	closeMaxIdleTime
+	// closeMaxIdle means the idle list was already full at MaxIdle
+	closeMaxIdle
+	// closeDrained means the connection's server was retired
+	closeDrained
+	// closeRejected means the OnAcquire hook rejected the connection
+	closeRejected
+	// closePoolClosed means the pool was closed
+	closePoolClosed
+	// closeLeaked means the connection was held longer than LeakThreshold
+	closeLeaked
+	// closeForced means Shutdown gave up waiting for the connection's return
+	closeForced
+)
+
+// closeReason reports why conn may not be handed out, or closeNone if it may:
+// it must be open, within its lifetime and idle time, and its server must not
+// be drained
+func (lcp *LdapConnPool) closeReason(conn *LdapConn) closeReason {
+	now := time.Now()
+	switch {
+	case conn.IsClosing():
+		return closeBroken
+	case now.Sub(conn.createdAt) > lcp.config.ConnMaxLifetime:
+		return closeMaxLifetime
+	case now.Sub(conn.lastUsed) > lcp.config.ConnMaxIdleTime:
+		return closeMaxIdleTime
+	case lcp.servers.retired(conn.server):
+		return closeDrained
+	}
+	return closeNone
+}
+
+// closeConnLocked closes conn and frees its slot, counting the close under
+// reason. Must be called with lcp.mu held.
+func (lcp *LdapConnPool) closeConnLocked(conn *LdapConn, reason closeReason) {
+	switch reason {
+	case closeBroken:
+		atomic.AddInt64(&lcp.brokenClosed, 1)
+	case closeMaxLifetime:
+		atomic.AddInt64(&lcp.maxLifetimeClosed, 1)
+	case closeMaxIdleTime:
+		atomic.AddInt64(&lcp.maxIdleTimeClosed, 1)
+	case closeMaxIdle:
+		atomic.AddInt64(&lcp.maxIdleClosed, 1)
+	case closeLeaked:
+		atomic.AddInt64(&lcp.leakedClosed, 1)
+	case closeDrained:
+		atomic.AddInt64(&lcp.drainedClosed, 1)
+	case closeRejected:
+		atomic.AddInt64(&lcp.rejectedClosed, 1)
+	case closePoolClosed:
+		atomic.AddInt64(&lcp.shutdownClosed, 1)
+	case closeForced:
+		atomic.AddInt64(&lcp.forcedClosed, 1)
+	}
+	level := slog.LevelDebug
+	if reason == closeBroken || reason == closeLeaked {
+		level = slog.LevelWarn
+	}
+	lcp.logger.Log(context.Background(), level, "ldap connection evicted",
+		slog.String("server", redactURL(conn.server)), slog.String("reason", reason.String()),
+		slog.Duration("age", time.Since(conn.createdAt)))
+	lcp.evict(conn, reason)
 	conn.Conn.Close()
-	atomic.AddInt32(&lcp.openConn, -1)
+	lcp.releaseSlotLocked()
 }
 
-// createConnection creates a new LDAP connection
-func (lcp *LdapConnPool) createConnection() (*LdapConn, error) {
-	timeout := lcp.config.ConnTimeout
-	if timeout <= 0 {
-		timeout = 30 * time.Second
+// releaseSlotLocked gives a slot in openConn back and lets a waiting request
+// use the freed capacity. Must be called with lcp.mu held.
+func (lcp *LdapConnPool) releaseSlotLocked() {
+	if atomic.AddInt32(&lcp.openConn, -1) == 0 && lcp.drained != nil {
+		close(lcp.drained)
+		lcp.drained = nil
 	}
+	lcp.openForWaitersLocked()
+}
 
-	var ldapConn *ldap.Conn
-	var err error
+// openForWaitersLocked dials new connections on behalf of waiting requests
+// while there is spare capacity. Must be called with lcp.mu held.
+func (lcp *LdapConnPool) openForWaitersLocked() {
+	for lcp.waiters.len() > 0 && atomic.LoadInt32(&lcp.openConn) < int32(lcp.config.MaxOpen) {
+		req := lcp.waiters.pop()
+		atomic.AddInt32(&lcp.openConn, 1)
+		go lcp.openForRequest(req)
+	}
+}
 
-	// Create dialer with timeout
-	dialer := &net.Dialer{Timeout: timeout}
+// openForRequest dials a connection for req, which has already been given a
+// reserved slot, and delivers either the connection or the dial error
+func (lcp *LdapConnPool) openForRequest(req *connRequest) {
+	conn, err := lcp.createConnection(req.ctx)
+	if err == nil && atomic.LoadInt32(&lcp.closed) == 1 {
+		conn.Conn.Close()
+		conn, err = nil, ErrPoolClosed
+	}
+	if err != nil {
+		lcp.mu.Lock()
+		lcp.releaseSlotLocked()
+		lcp.mu.Unlock()
+	}
+	req.ch <- connResult{conn: conn, err: err}
+}
 
-	// Prepare TLS config if needed
-	tlsConfig := lcp.config.TLSConfig
-	if tlsConfig == nil && (lcp.config.InsecureSkipVerify || lcp.config.UseStartTLS) {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: lcp.config.InsecureSkipVerify,
-		}
+// createConnection creates a new LDAP connection, failing fast with
+// ErrCircuitOpen while the circuit breaker is open. The caller is responsible
+// for reserving a slot in openConn beforehand.
+func (lcp *LdapConnPool) createConnection(ctx context.Context) (*LdapConn, error) {
+	if err := lcp.breaker.allow(); err != nil {
+		return nil, err
+	}
+	conn, err := lcp.dialServers(ctx)
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up, which says nothing about the servers
+		lcp.breaker.abort()
+		return nil, ctx.Err()
 	}
+	lcp.breaker.record(err)
+	lcp.recordHealth(err)
+	return conn, err
+}
 
-	// Check URL scheme to determine connection type
-	if len(lcp.config.Url) > 8 && lcp.config.Url[:8] == "ldaps://" {
-		// LDAPS connection (TLS from start)
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{}
+// recordHealth remembers the outcome of the latest dial for PoolStats
+func (lcp *LdapConnPool) recordHealth(err error) {
+	lcp.healthMu.Lock()
+	defer lcp.healthMu.Unlock()
+	lcp.healthy = err == nil
+	lcp.lastErr, lcp.lastErrAt = err, time.Time{}
+	if err != nil {
+		lcp.lastErrAt = time.Now()
+	}
+}
+
+// dialServers tries the configured servers in turn. Servers that cannot be
+// reached are taken out of rotation for ServerBackoff.
+func (lcp *LdapConnPool) dialServers(ctx context.Context) (*LdapConn, error) {
+	candidates := lcp.servers.candidates()
+	if len(candidates) == 0 && lcp.config.SRVDomain != "" {
+		if err := lcp.refreshServers(); err != nil {
+			return nil, err
 		}
-		ldapConn, err = ldap.DialURL(lcp.config.Url,
-			ldap.DialWithDialer(dialer),
-			ldap.DialWithTLSConfig(tlsConfig))
-	} else {
-		// Plain LDAP connection
-		ldapConn, err = ldap.DialURL(lcp.config.Url, ldap.DialWithDialer(dialer))
-		if err != nil {
-			return nil, fmt.Errorf("failed to dial LDAP server: %w", err)
+		candidates = lcp.servers.candidates()
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoServers
+	}
+
+	var lastErr error
+	for _, url := range candidates {
+		ldapConn, wire, err := lcp.dial(ctx, url)
+		if err == nil {
+			lcp.servers.markUp(url)
+			atomic.AddInt64(&lcp.dials, 1)
+			now := time.Now()
+			return &LdapConn{
+				Conn:      ldapConn,
+				createdAt: now,
+				lastUsed:  now,
+				server:    url,
+				pool:      lcp,
+				wire:      wire,
+			}, nil
 		}
 
-		// Upgrade to TLS using StartTLS if requested
-		if lcp.config.UseStartTLS {
-			if tlsConfig == nil {
-				tlsConfig = &tls.Config{}
-			}
-			err = ldapConn.StartTLS(tlsConfig)
-			if err != nil {
-				ldapConn.Close()
-				return nil, fmt.Errorf("failed to start TLS: %w", err)
-			}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		// A rejected bind would be rejected by every replica as well
+		var netErr *serverError
+		if !errors.As(err, &netErr) {
+			return nil, err
+		}
+		lcp.servers.markDown(url)
+		lcp.logger.Warn("ldap server marked down", slog.String("server", redactURL(url)),
+			slog.Duration("backoff", lcp.config.ServerBackoff), slog.Any("error", netErr.err))
+		lastErr = netErr.err
 	}
+	return nil, lastErr
+}
+
+// serverError marks a dial error caused by the server being unreachable, as
+// opposed to the server rejecting the bind
+type serverError struct {
+	err error
+}
+
+func (e *serverError) Error() string { return e.err.Error() }
+func (e *serverError) Unwrap() error { return e.err }
 
+// dial opens and binds a connection to url
+func (lcp *LdapConnPool) dial(ctx context.Context, url string) (*ldap.Conn, *wireConn, error) {
+	start := time.Now()
+	ldapConn, wire, err := lcp.connect(ctx, url)
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	lcp.observeDial(url, start, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial LDAP server: %w", err)
+		atomic.AddInt64(&lcp.dialFailures, 1)
+		lcp.logger.Warn("ldap dial failed", slog.String("server", redactURL(url)), slog.Any("error", err))
+		return nil, nil, &serverError{err}
+	}
+	if lcp.config.Hooks != nil {
+		if err := lcp.config.Hooks.OnDial(ctx, url, ldapConn); err != nil {
+			ldapConn.Close()
+			return nil, nil, fmt.Errorf("connection rejected by OnDial hook: %w", err)
+		}
 	}
 
 	// Bind with admin credentials
+	start = time.Now()
+	_, span := lcp.startSpan(ctx, "ldapool.bind", attrServer.String(url))
 	err = ldapConn.Bind(lcp.config.AdminDN, lcp.config.AdminPass)
+	endOpSpan(span, err)
+	lcp.observeBind(url, start, err)
 	if err != nil {
 		ldapConn.Close()
-		return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
+		atomic.AddInt64(&lcp.bindFailures, 1)
+		// Only the bind DN is logged, never the password
+		lcp.logger.Warn("ldap bind failed", slog.String("server", redactURL(url)),
+			slog.String("bind_dn", lcp.config.AdminDN), slog.Any("error", err))
+		err = fmt.Errorf("failed to bind to LDAP server: %w", err)
+		if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			return nil, nil, &serverError{err}
+		}
+		return nil, nil, err
+	}
+	if lcp.config.Hooks != nil {
+		if err := lcp.config.Hooks.OnBind(ctx, url, ldapConn); err != nil {
+			ldapConn.Close()
+			return nil, nil, fmt.Errorf("connection rejected by OnBind hook: %w", err)
+		}
 	}
 
-	now := time.Now()
-	conn := &LdapConn{
-		Conn:      ldapConn,
-		createdAt: now,
-		lastUsed:  now,
-		pool:      lcp,
+	return ldapConn, wire, nil
+}
+
+// connect opens a connection to url, upgrading it with StartTLS if configured
+func (lcp *LdapConnPool) connect(ctx context.Context, url string) (*ldap.Conn, *wireConn, error) {
+	timeout := lcp.config.ConnTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
 	}
 
-	atomic.AddInt32(&lcp.openConn, 1)
-	return conn, nil
+	// Create dialer with timeout
+	dialer := &net.Dialer{Timeout: timeout}
+
+	// Prepare TLS config if needed
+	tlsConfig := lcp.config.TLSConfig
+	if tlsConfig == nil && (lcp.config.InsecureSkipVerify || lcp.config.UseStartTLS) {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: lcp.config.InsecureSkipVerify,
+		}
+	}
+
+	_, span := lcp.startSpan(ctx, "ldapool.dial", attrServer.String(url))
+	conn, host, err := dialWire(ctx, url, dialer, tlsConfig)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial LDAP server: %w", err)
+	}
+
+	// Check URL scheme to determine connection type
+	isTLS := strings.HasPrefix(url, "ldaps://")
+	if !isTLS && lcp.config.UseStartTLS {
+		// Upgrade to TLS using StartTLS
+		_, span := lcp.startSpan(ctx, "ldapool.starttls", attrServer.String(url))
+		conn, err = startTLS(ctx, conn, host, tlsConfig, timeout)
+		endSpan(span, err)
+		if err != nil {
+			lcp.logger.Warn("ldap starttls failed", slog.String("server", redactURL(url)), slog.Any("error", err))
+			return nil, nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+		isTLS = true
+	}
+
+	wire := newWireConn(conn)
+	ldapConn := ldap.NewConn(wire, isTLS)
+	ldapConn.Start()
+	return ldapConn, wire, nil
 }
 
-// cleanup periodically cleans up expired connections
+// cleanup periodically cleans up expired connections and, when configured,
+// probes idle connections and re-resolves SRV records
 func (lcp *LdapConnPool) cleanup() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
+	var healthCheck <-chan time.Time
+	if lcp.config.HealthCheckInterval > 0 {
+		healthTicker := time.NewTicker(lcp.config.HealthCheckInterval)
+		defer healthTicker.Stop()
+		healthCheck = healthTicker.C
+	}
+
+	var leakCheck <-chan time.Time
+	if lcp.config.LeakThreshold > 0 {
+		leakTicker := time.NewTicker(lcp.config.LeakThreshold)
+		defer leakTicker.Stop()
+		leakCheck = leakTicker.C
+	}
+
+	var srvRefresh <-chan time.Time
+	if lcp.config.SRVDomain != "" {
+		srvTicker := time.NewTicker(lcp.config.SRVRefreshInterval)
+		defer srvTicker.Stop()
+		srvRefresh = srvTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			lcp.cleanupExpiredConnections()
+			lcp.maintain()
+		case <-healthCheck:
+			lcp.checkIdleHealth()
+			lcp.fillIdle()
+		case <-leakCheck:
+			lcp.checkLeaks()
+		case <-srvRefresh:
+			lcp.refreshServers()
 		case <-lcp.stopCleanup:
 			return
 		}
 	}
 }
 
+// maintain runs one round of background housekeeping
+func (lcp *LdapConnPool) maintain() {
+	lcp.cleanupExpiredConnections()
+	lcp.fillIdle()
+}
+
+// warmup fills the pool up to MinIdle, recording progress for PoolStats
+func (lcp *LdapConnPool) warmup() error {
+	defer atomic.StoreInt32(&lcp.warming, 0)
+	for {
+		opened, err := lcp.openIdleConn()
+		if err != nil || !opened {
+			return err
+		}
+		atomic.AddInt32(&lcp.warmed, 1)
+	}
+}
+
+// fillIdle tops the pool back up to MinIdle after evictions
+func (lcp *LdapConnPool) fillIdle() error {
+	for {
+		opened, err := lcp.openIdleConn()
+		if err != nil || !opened {
+			return err
+		}
+	}
+}
+
+// openIdleConn dials one connection into the pool if it is below MinIdle and
+// has spare capacity, reporting whether a connection was added
+func (lcp *LdapConnPool) openIdleConn() (bool, error) {
+	lcp.mu.Lock()
+	if atomic.LoadInt32(&lcp.closed) == 1 ||
+		len(lcp.conns) >= lcp.config.MinIdle ||
+		atomic.LoadInt32(&lcp.openConn) >= int32(lcp.config.MaxOpen) {
+		lcp.mu.Unlock()
+		return false, nil
+	}
+	atomic.AddInt32(&lcp.openConn, 1)
+	lcp.mu.Unlock()
+
+	conn, err := lcp.createConnection(context.Background())
+	if err != nil {
+		lcp.mu.Lock()
+		lcp.releaseSlotLocked()
+		lcp.mu.Unlock()
+		return false, err
+	}
+	lcp.putConnection(conn, time.Now())
+	return true, nil
+}
+
 // cleanupExpiredConnections removes expired connections from the pool
 func (lcp *LdapConnPool) cleanupExpiredConnections() {
 	lcp.mu.Lock()
@@ -385,42 +1169,169 @@ func (lcp *LdapConnPool) cleanupExpiredConnections() {
 
 	validConns := make([]*LdapConn, 0, len(lcp.conns))
 	for _, conn := range lcp.conns {
-		if !conn.IsClosing() && !conn.IsExpired(lcp.config.ConnMaxLifetime, lcp.config.ConnMaxIdleTime) {
+		if reason := lcp.closeReason(conn); reason == closeNone {
 			validConns = append(validConns, conn)
 		} else {
-			conn.Conn.Close()
-			atomic.AddInt32(&lcp.openConn, -1)
+			lcp.closeConnLocked(conn, reason)
 		}
 	}
 	lcp.conns = validConns
 }
 
-// Close closes the connection pool
+// checkIdleHealth probes every idle connection and evicts the ones that fail.
+// Each connection is taken out of the pool while it is probed so callers
+// never receive a connection that is in the middle of a probe.
+func (lcp *LdapConnPool) checkIdleHealth() {
+	lcp.mu.Lock()
+	idle := make([]*LdapConn, len(lcp.conns))
+	copy(idle, lcp.conns)
+	lcp.mu.Unlock()
+
+	for _, conn := range idle {
+		if !lcp.takeIdle(conn) {
+			// Already borrowed or evicted
+			continue
+		}
+
+		lastUsed := conn.lastUsed
+		if err := conn.ping(lcp.config.ConnTimeout); err != nil {
+			lcp.mu.Lock()
+			lcp.closeConnLocked(conn, closeBroken)
+			lcp.mu.Unlock()
+			continue
+		}
+		lcp.putConnection(conn, lastUsed)
+	}
+}
+
+// takeIdle removes conn from the idle list, reporting whether it was there
+func (lcp *LdapConnPool) takeIdle(conn *LdapConn) bool {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	for i, c := range lcp.conns {
+		if c == conn {
+			lcp.conns = append(lcp.conns[:i], lcp.conns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetServers replaces the servers new connections are dialed to. Idle
+// connections to servers that are no longer listed are closed right away,
+// borrowed ones when they are returned.
+func (lcp *LdapConnPool) SetServers(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("%w: URL is required", ErrInvalidConfig)
+	}
+	for _, url := range urls {
+		if url == "" {
+			return fmt.Errorf("%w: empty server URL", ErrInvalidConfig)
+		}
+	}
+	lcp.servers.set(urls)
+	lcp.cleanupExpiredConnections()
+	return nil
+}
+
+// Ping checks that the directory is reachable by borrowing a connection and
+// probing it with a RootDSE search
+func (lcp *LdapConnPool) Ping(ctx context.Context) error {
+	conn, err := lcp.GetConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeout := lcp.config.ConnTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	err = conn.ping(timeout)
+	lcp.recordHealth(err)
+	return err
+}
+
+// Close closes the connection pool. Idle connections are closed right away
+// and borrowed ones when they are returned; waiting requests fail with
+// ErrPoolClosed.
 func (lcp *LdapConnPool) Close() error {
-	if !atomic.CompareAndSwapInt32(&lcp.closed, 0, 1) {
+	if !lcp.close() {
 		return ErrPoolClosed
 	}
+	return nil
+}
+
+// Shutdown closes the pool gracefully. It stops handing out connections,
+// fails waiting requests with ErrPoolClosed and waits until every borrowed
+// connection is returned. If ctx expires first the remaining connections are
+// closed forcibly; Shutdown then returns how many were forced along with the
+// context's error.
+func (lcp *LdapConnPool) Shutdown(ctx context.Context) (int, error) {
+	if !lcp.close() {
+		return 0, ErrPoolClosed
+	}
+
+	lcp.mu.Lock()
+	if atomic.LoadInt32(&lcp.openConn) == 0 {
+		lcp.mu.Unlock()
+		return 0, nil
+	}
+	drained := make(chan struct{})
+	lcp.drained = drained
+	lcp.mu.Unlock()
+
+	select {
+	case <-drained:
+		return 0, nil
+	case <-ctx.Done():
+	}
+
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	forced := 0
+	for conn := range lcp.borrowed {
+		if lcp.forceCloseLocked(conn, closeForced) {
+			forced++
+		}
+	}
+	lcp.drained = nil
+	if forced > 0 {
+		lcp.logger.Warn("ldap pool shutdown forced connections closed", slog.Int("forced", forced))
+	}
+	return forced, ctx.Err()
+}
+
+// close marks the pool closed, fails waiting requests and closes the idle
+// connections, reporting whether the pool was open
+func (lcp *LdapConnPool) close() bool {
+	if !atomic.CompareAndSwapInt32(&lcp.closed, 0, 1) {
+		return false
+	}
 
 	lcp.cleanupOnce.Do(func() {
 		close(lcp.stopCleanup)
 	})
+	if lcp.metrics != nil {
+		lcp.metrics.Unregister()
+	}
+	lcp.logger.Info("ldap pool closed")
 
 	lcp.mu.Lock()
 	defer lcp.mu.Unlock()
 
-	// Close all connections
-	for _, conn := range lcp.conns {
-		conn.Conn.Close()
+	// Fail all waiting requests
+	for req := lcp.waiters.pop(); req != nil; req = lcp.waiters.pop() {
+		req.ch <- connResult{err: ErrPoolClosed}
 	}
-	lcp.conns = nil
 
-	// Close all waiting requests
-	for _, req := range lcp.reqConns {
-		close(req)
+	// Close all idle connections
+	for _, conn := range lcp.conns {
+		lcp.closeConnLocked(conn, closePoolClosed)
 	}
-	lcp.reqConns = nil
+	lcp.conns = nil
 
-	return nil
+	return true
 }
 
 // Stats returns pool statistics
@@ -430,13 +1341,104 @@ func (lcp *LdapConnPool) Stats() (open, idle int) {
 	return int(atomic.LoadInt32(&lcp.openConn)), len(lcp.conns)
 }
 
-// nextRequestKeyLocked generates a unique request key
-func (lcp *LdapConnPool) nextRequestKeyLocked() uint64 {
-	for {
-		reqKey := rand.Uint64()
-		if _, ok := lcp.reqConns[reqKey]; !ok {
-			return reqKey
-		}
+// PoolStats is a snapshot of the pool state
+type PoolStats struct {
+	// maximum number of open connections (MaxOpen)
+	MaxOpen int
+	// number of established connections, both in use and idle
+	Open int
+	// number of connections currently borrowed or being dialed
+	InUse int
+	// number of idle connections
+	Idle int
+	// number of requests currently waiting for a connection
+	Waiters int
+
+	// total number of connections opened
+	Dials int64
+	// total number of requests that had to wait for a connection
+	WaitCount int64
+	// total time spent waiting for connections
+	WaitDuration time.Duration
+	// total connections closed because the idle list was full (MaxIdle)
+	MaxIdleClosed int64
+	// total connections closed for exceeding ConnMaxIdleTime
+	MaxIdleTimeClosed int64
+	// total connections closed for exceeding ConnMaxLifetime
+	MaxLifetimeClosed int64
+	// total connections closed because they were broken or failed a probe
+	BrokenClosed int64
+	// total failed dials, including StartTLS failures
+	DialFailures int64
+	// total failed binds
+	BindFailures int64
+	// total connections reclaimed after being held longer than LeakThreshold
+	LeakedClosed int64
+	// total connections closed because their server was retired
+	DrainedClosed int64
+	// total connections closed because the OnAcquire hook rejected them
+	RejectedClosed int64
+	// total connections closed by Close or Shutdown
+	ShutdownClosed int64
+	// total borrowed connections closed when Shutdown gave up waiting
+	ForcedClosed int64
+	// total requests abandoned because their context was done
+	Abandoned int64
+
+	// number of idle connections the pool keeps ready (MinIdle)
+	WarmupTarget int
+	// number of connections opened by the initial warm-up
+	WarmedUp int
+	// whether the initial warm-up is still in progress
+	Warming bool
+	// whether the circuit breaker is rejecting new connections
+	CircuitOpen bool
+	// whether the latest dial or Ping succeeded, false until the pool has
+	// reached the directory once
+	Healthy bool
+	// error of the latest dial or Ping, nil when healthy
+	LastError error
+	// when LastError occurred
+	LastErrorAt time.Time
+}
+
+// PoolStats returns a detailed snapshot of pool statistics
+func (lcp *LdapConnPool) PoolStats() PoolStats {
+	lcp.healthMu.Lock()
+	healthy, lastErr, lastErrAt := lcp.healthy, lcp.lastErr, lcp.lastErrAt
+	lcp.healthMu.Unlock()
+
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	open := int(atomic.LoadInt32(&lcp.openConn))
+	return PoolStats{
+		MaxOpen:           lcp.config.MaxOpen,
+		Open:              open,
+		InUse:             open - len(lcp.conns),
+		Idle:              len(lcp.conns),
+		Waiters:           lcp.waiters.len(),
+		Dials:             atomic.LoadInt64(&lcp.dials),
+		WaitCount:         atomic.LoadInt64(&lcp.waitCount),
+		WaitDuration:      time.Duration(atomic.LoadInt64(&lcp.waitDuration)),
+		MaxIdleClosed:     atomic.LoadInt64(&lcp.maxIdleClosed),
+		MaxIdleTimeClosed: atomic.LoadInt64(&lcp.maxIdleTimeClosed),
+		MaxLifetimeClosed: atomic.LoadInt64(&lcp.maxLifetimeClosed),
+		BrokenClosed:      atomic.LoadInt64(&lcp.brokenClosed),
+		DialFailures:      atomic.LoadInt64(&lcp.dialFailures),
+		BindFailures:      atomic.LoadInt64(&lcp.bindFailures),
+		LeakedClosed:      atomic.LoadInt64(&lcp.leakedClosed),
+		DrainedClosed:     atomic.LoadInt64(&lcp.drainedClosed),
+		RejectedClosed:    atomic.LoadInt64(&lcp.rejectedClosed),
+		ShutdownClosed:    atomic.LoadInt64(&lcp.shutdownClosed),
+		ForcedClosed:      atomic.LoadInt64(&lcp.forcedClosed),
+		Abandoned:         atomic.LoadInt64(&lcp.abandoned),
+		WarmupTarget:      lcp.config.MinIdle,
+		WarmedUp:          int(atomic.LoadInt32(&lcp.warmed)),
+		Warming:           atomic.LoadInt32(&lcp.warming) == 1,
+		CircuitOpen:       lcp.breaker.isOpen(),
+		Healthy:           healthy,
+		LastError:         lastErr,
+		LastErrorAt:       lastErrAt,
 	}
 }
 
@@ -3,6 +3,7 @@ package ldapool
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -605,3 +606,892 @@ func TestTLSConfigValidation(t *testing.T) {
 		// If we reach here, the pool was created successfully despite UseStartTLS being set
 	})
 }
+
+func TestMaxOpenReservation(t *testing.T) {
+	server := newFakeServer(t)
+	server.bindDelay = 5 * time.Millisecond
+
+	t.Run("Concurrent dials never exceed MaxOpen", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 3
+		config.MaxIdle = 3
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+		server.waitIdle()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 40)
+		for i := 0; i < 40; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					conn, err := pool.GetConnection(context.Background())
+					if err != nil {
+						errs <- fmt.Errorf("goroutine %d: %v", id, err)
+						return
+					}
+					if open, _ := pool.Stats(); open > config.MaxOpen {
+						errs <- fmt.Errorf("goroutine %d: %d open connections exceed MaxOpen", id, open)
+					}
+					time.Sleep(time.Millisecond)
+					conn.Close()
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Error(err)
+		}
+		if max := server.MaxLive(); max > config.MaxOpen {
+			t.Errorf("Server saw %d simultaneous connections, MaxOpen is %d", max, config.MaxOpen)
+		}
+	})
+
+	t.Run("Failed bind releases the reservation", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 2
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		server.setPassword("changed")
+		defer server.setPassword(config.AdminPass)
+
+		for i := 0; i < 5; i++ {
+			if _, err := pool.GetConnection(context.Background()); err == nil {
+				t.Fatal("Expected bind failure")
+			}
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected 0 open connections after failed dials, got %d", open)
+		}
+	})
+}
+
+func TestMinIdleWarmup(t *testing.T) {
+	server := newFakeServer(t)
+
+	t.Run("Synchronous warm-up", func(t *testing.T) {
+		config := server.config()
+		config.MinIdle = 3
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		stats := pool.PoolStats()
+		if stats.Idle != 3 || stats.Open != 3 {
+			t.Errorf("Expected 3 open and idle connections, got %d open, %d idle", stats.Open, stats.Idle)
+		}
+		if stats.Warming || stats.WarmedUp != 3 || stats.WarmupTarget != 3 {
+			t.Errorf("Unexpected warm-up progress: %+v", stats)
+		}
+	})
+
+	t.Run("Asynchronous warm-up", func(t *testing.T) {
+		config := server.config()
+		config.MinIdle = 4
+		config.AsyncWarmup = true
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for pool.PoolStats().Warming {
+			if time.Now().After(deadline) {
+				t.Fatal("Warm-up did not finish")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if stats := pool.PoolStats(); stats.Idle != 4 || stats.WarmedUp != 4 {
+			t.Errorf("Expected 4 warm connections, got %+v", stats)
+		}
+	})
+
+	t.Run("MinIdle capped at MaxIdle", func(t *testing.T) {
+		config := server.config()
+		config.MaxIdle = 2
+		config.MinIdle = 5
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		if _, idle := pool.Stats(); idle != 2 {
+			t.Errorf("Expected 2 idle connections, got %d", idle)
+		}
+	})
+
+	t.Run("Maintenance tops up after eviction", func(t *testing.T) {
+		config := server.config()
+		config.MinIdle = 2
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		pool.mu.Lock()
+		for _, conn := range pool.conns {
+			conn.createdAt = time.Now().Add(-2 * config.ConnMaxLifetime)
+		}
+		pool.mu.Unlock()
+
+		pool.maintain()
+
+		open, idle := pool.Stats()
+		if open != 2 || idle != 2 {
+			t.Errorf("Expected 2 open and idle connections after top-up, got %d open, %d idle", open, idle)
+		}
+		pool.mu.Lock()
+		for _, conn := range pool.conns {
+			if conn.IsExpired(config.ConnMaxLifetime, config.ConnMaxIdleTime) {
+				t.Error("Expected expired connections to be replaced")
+			}
+		}
+		pool.mu.Unlock()
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+	server := newFakeServer(t)
+
+	t.Run("Idle probe evicts unresponsive connections", func(t *testing.T) {
+		config := server.config()
+		config.MinIdle = 2
+		config.ConnTimeout = 100 * time.Millisecond
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		pool.checkIdleHealth()
+		if open, idle := pool.Stats(); open != 2 || idle != 2 {
+			t.Errorf("Expected healthy connections to stay, got %d open, %d idle", open, idle)
+		}
+
+		server.setHang(true)
+		defer server.setHang(false)
+
+		pool.checkIdleHealth()
+		if open, idle := pool.Stats(); open != 0 || idle != 0 {
+			t.Errorf("Expected unresponsive connections to be evicted, got %d open, %d idle", open, idle)
+		}
+	})
+
+	t.Run("Probe keeps idle time", func(t *testing.T) {
+		config := server.config()
+		config.MinIdle = 1
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		lastUsed := time.Now().Add(-time.Minute)
+		pool.mu.Lock()
+		pool.conns[0].lastUsed = lastUsed
+		pool.mu.Unlock()
+
+		pool.checkIdleHealth()
+
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		if len(pool.conns) != 1 || !pool.conns[0].lastUsed.Equal(lastUsed) {
+			t.Error("Expected the probe to leave lastUsed untouched")
+		}
+	})
+
+	t.Run("Validate on borrow", func(t *testing.T) {
+		config := server.config()
+		config.ConnTimeout = 100 * time.Millisecond
+		config.ValidateOnBorrow = true
+
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		stale, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		stale.Close()
+
+		server.setHang(true)
+		conn, err := pool.GetConnection(context.Background())
+		server.setHang(false)
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+
+		if conn.pooled == stale.pooled {
+			t.Error("Expected the unresponsive idle connection to be replaced")
+		}
+		if open, _ := pool.Stats(); open != 1 {
+			t.Errorf("Expected 1 open connection, got %d", open)
+		}
+	})
+}
+
+func TestLazyConnect(t *testing.T) {
+	t.Run("Unreachable server at startup", func(t *testing.T) {
+		server := newFakeServer(t)
+		server.Close()
+
+		config := server.config()
+		if _, err := NewPool(config); err == nil {
+			t.Fatal("Expected eager pool creation to fail")
+		}
+
+		config.LazyConnect = true
+		config.MinIdle = 2
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Expected lazy pool creation to succeed, got %v", err)
+		}
+		defer pool.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := pool.Ping(ctx); err == nil {
+			t.Error("Expected Ping to fail")
+		}
+		stats := pool.PoolStats()
+		if stats.Healthy || stats.LastError == nil || stats.LastErrorAt.IsZero() {
+			t.Errorf("Expected stats to report the failure, got %+v", stats)
+		}
+	})
+
+	t.Run("Never dialed pool is not healthy", func(t *testing.T) {
+		server := newFakeServer(t)
+		config := server.config()
+		config.LazyConnect = true
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		if stats := pool.PoolStats(); stats.Healthy || stats.LastError != nil {
+			t.Errorf("Expected an unknown health before the first dial, got %+v", stats)
+		}
+		if err := pool.Ping(context.Background()); err != nil {
+			t.Fatalf("Expected Ping to succeed, got %v", err)
+		}
+		if stats := pool.PoolStats(); !stats.Healthy {
+			t.Errorf("Expected healthy stats after the first dial, got %+v", stats)
+		}
+	})
+
+	t.Run("Failed discovery is reported", func(t *testing.T) {
+		config := getTestConfig()
+		config.LazyConnect = true
+		config.SRVDomain = "eryajf.net"
+		config.Resolver = &fakeResolver{err: errors.New("SERVFAIL")}
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		if stats := pool.PoolStats(); stats.Healthy || stats.LastError == nil {
+			t.Errorf("Expected stats to report the failed lookup, got %+v", stats)
+		}
+	})
+
+	t.Run("Recovers once the server accepts binds", func(t *testing.T) {
+		server := newFakeServer(t)
+		server.setPassword("changed")
+
+		config := server.config()
+		config.LazyConnect = true
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		if server.Accepted() != 0 {
+			t.Error("Expected lazy pool not to dial on creation")
+		}
+		if err := pool.Ping(context.Background()); err == nil {
+			t.Fatal("Expected Ping to fail with rejected bind")
+		}
+
+		server.setPassword(config.AdminPass)
+		if err := pool.Ping(context.Background()); err != nil {
+			t.Fatalf("Expected Ping to succeed, got %v", err)
+		}
+		if stats := pool.PoolStats(); !stats.Healthy || stats.LastError != nil {
+			t.Errorf("Expected healthy stats, got %+v", stats)
+		}
+		if open, idle := pool.Stats(); open != 1 || idle != 1 {
+			t.Errorf("Expected the Ping connection to be pooled, got %d open, %d idle", open, idle)
+		}
+	})
+}
+
+func TestDefaultPool(t *testing.T) {
+	t.Cleanup(func() { CloseDefault() })
+
+	t.Run("Failed init can be retried", func(t *testing.T) {
+		server := newFakeServer(t)
+		config := server.config()
+		server.setPassword("changed")
+
+		if err := InitDefault(config); err == nil {
+			t.Fatal("Expected init to fail")
+		}
+		if GetDefault() != nil {
+			t.Fatal("Expected no default pool after failed init")
+		}
+		if _, err := Open(config); err == nil {
+			t.Fatal("Expected Open to fail")
+		}
+
+		server.setPassword(config.AdminPass)
+		conn, err := Open(config)
+		if err != nil {
+			t.Fatalf("Expected retry to succeed, got %v", err)
+		}
+		conn.Close()
+		if GetDefault() == nil {
+			t.Error("Expected default pool after successful retry")
+		}
+
+		if err := CloseDefault(); err != nil {
+			t.Errorf("Failed to close default pool: %v", err)
+		}
+		if GetDefault() != nil {
+			t.Error("Expected no default pool after CloseDefault")
+		}
+		if err := CloseDefault(); err != nil {
+			t.Errorf("Expected closing a missing default pool to be a no-op, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent init creates a single pool", func(t *testing.T) {
+		server := newFakeServer(t)
+		config := server.config()
+		defer CloseDefault()
+
+		var wg sync.WaitGroup
+		pools := make(chan *LdapConnPool, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := Open(config)
+				if err != nil {
+					t.Errorf("Open failed: %v", err)
+					return
+				}
+				pools <- conn.pool
+				conn.Close()
+			}()
+		}
+		wg.Wait()
+		close(pools)
+
+		want := GetDefault()
+		for pool := range pools {
+			if pool != want {
+				t.Fatal("Expected every connection to come from the same default pool")
+			}
+		}
+	})
+
+	t.Run("Reset replaces the pool", func(t *testing.T) {
+		first := newFakeServer(t)
+		second := newFakeServer(t)
+		defer CloseDefault()
+
+		if err := InitDefault(first.config()); err != nil {
+			t.Fatalf("Failed to init default pool: %v", err)
+		}
+		old := GetDefault()
+
+		bad := second.config()
+		bad.AdminPass = "wrong"
+		if err := ResetDefault(bad); err == nil {
+			t.Fatal("Expected reset with bad credentials to fail")
+		}
+		if GetDefault() != old {
+			t.Fatal("Expected failed reset to keep the current pool")
+		}
+
+		if err := ResetDefault(second.config()); err != nil {
+			t.Fatalf("Failed to reset default pool: %v", err)
+		}
+		if GetDefault() == old {
+			t.Error("Expected a new default pool")
+		}
+		if _, err := old.GetConnection(context.Background()); err != ErrPoolClosed {
+			t.Errorf("Expected the old pool to be closed, got %v", err)
+		}
+		conn, err := Open(first.config())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != second.url {
+			t.Errorf("Expected connection to %s, got %s", second.url, conn.Server())
+		}
+	})
+
+	t.Run("Slow init does not block the default pool accessors", func(t *testing.T) {
+		server := newFakeServer(t)
+		server.bindDelay = 500 * time.Millisecond
+		defer CloseDefault()
+
+		initErr := make(chan error, 1)
+		go func() { initErr <- InitDefault(server.config()) }()
+		for server.Accepted() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		start := time.Now()
+		if GetDefault() != nil {
+			t.Error("Expected no default pool while it is being created")
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Errorf("Expected GetDefault not to wait for the dial, took %v", elapsed)
+		}
+
+		if err := <-initErr; err != nil {
+			t.Fatalf("Failed to init default pool: %v", err)
+		}
+		if GetDefault() == nil {
+			t.Error("Expected the default pool once created")
+		}
+	})
+}
+
+func TestPoolStatsCounters(t *testing.T) {
+	server := newFakeServer(t)
+
+	t.Run("Wait and in-use counters", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn, err := pool.GetConnection(context.Background())
+			if err == nil {
+				conn.Close()
+			}
+		}()
+		waitForWaiters(t, pool, 1)
+
+		stats := pool.PoolStats()
+		if stats.MaxOpen != 1 || stats.InUse != 1 || stats.Waiters != 1 {
+			t.Errorf("Unexpected stats while saturated: %+v", stats)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		held.Close()
+		<-done
+
+		stats = pool.PoolStats()
+		if stats.WaitCount != 1 || stats.WaitDuration < 10*time.Millisecond {
+			t.Errorf("Expected one wait of at least 10ms, got %d waits, %v", stats.WaitCount, stats.WaitDuration)
+		}
+		if stats.InUse != 0 || stats.Waiters != 0 {
+			t.Errorf("Expected nothing in use or waiting, got %+v", stats)
+		}
+	})
+
+	t.Run("Close reasons", func(t *testing.T) {
+		config := server.config()
+		config.MaxIdle = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		conns := make([]*LdapConn, 4)
+		for i := range conns {
+			if conns[i], err = pool.GetConnection(context.Background()); err != nil {
+				t.Fatalf("Failed to get connection: %v", err)
+			}
+		}
+		conns[0].pooled.createdAt = time.Now().Add(-2 * config.ConnMaxLifetime)
+		conns[1].Conn.Close()
+		conns[2].Close()
+		conns[3].Close()
+		conns[0].Close()
+		conns[1].Close()
+
+		pool.mu.Lock()
+		pool.conns[0].lastUsed = time.Now().Add(-2 * config.ConnMaxIdleTime)
+		pool.mu.Unlock()
+		pool.cleanupExpiredConnections()
+
+		stats := pool.PoolStats()
+		if stats.MaxLifetimeClosed != 1 || stats.BrokenClosed != 1 ||
+			stats.MaxIdleClosed != 1 || stats.MaxIdleTimeClosed != 1 {
+			t.Errorf("Unexpected close counters: %+v", stats)
+		}
+		if stats.Open != 0 {
+			t.Errorf("Expected 0 open connections, got %d", stats.Open)
+		}
+	})
+
+	t.Run("Dial and bind failures", func(t *testing.T) {
+		config := server.config()
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		server.setPassword("changed")
+		pool.GetConnection(context.Background())
+		server.setPassword(config.AdminPass)
+
+		down := newFakeServer(t)
+		down.Close()
+		pool.SetServers([]string{down.url})
+		pool.GetConnection(context.Background())
+
+		stats := pool.PoolStats()
+		if stats.BindFailures != 1 || stats.DialFailures != 1 {
+			t.Errorf("Expected 1 bind and 1 dial failure, got %d and %d", stats.BindFailures, stats.DialFailures)
+		}
+	})
+}
+
+func TestConnDoubleClose(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 2
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("First close failed: %v", err)
+	}
+	if err := conn.Close(); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed on second close, got %v", err)
+	}
+	pool.PutConnection(conn)
+	if open, idle := pool.Stats(); open != 1 || idle != 1 {
+		t.Errorf("Expected 1 open idle connection, got %d open and %d idle", open, idle)
+	}
+
+	// The same connection lent out again is not affected by the stale handle
+	next, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer next.Close()
+	if next.pooled != conn.pooled {
+		t.Fatal("Expected the idle connection to be reused")
+	}
+	if err := conn.Close(); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed from stale handle, got %v", err)
+	}
+	if stats := pool.PoolStats(); stats.InUse != 1 || stats.Idle != 0 {
+		t.Errorf("Expected the reused connection to stay in use, got %+v", stats)
+	}
+
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	if _, err := conn.Search(req); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed from search on stale handle, got %v", err)
+	}
+	if err := conn.Del(ldap.NewDelRequest("cn=x,"+config.BaseDN, nil)); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed from delete on stale handle, got %v", err)
+	}
+	if _, err := next.Search(req); err != nil {
+		t.Errorf("Search on the current handle failed: %v", err)
+	}
+}
+
+func TestStaleHandleCannotTouchSocket(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	stale, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	stale.Close()
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	calls := map[string]error{
+		"Unbind":              stale.Unbind(),
+		"StartTLS":            stale.StartTLS(&tls.Config{}),
+		"UnauthenticatedBind": stale.UnauthenticatedBind("cn=x"),
+		"ExternalBind":        stale.ExternalBind(),
+		"NTLMBind":            stale.NTLMBind("EXAMPLE", "user", "secret"),
+		"MD5Bind":             stale.MD5Bind("localhost", "user", "secret"),
+		"SearchAsync":         stale.SearchAsync(context.Background(), req, 0).Err(),
+		"DirSyncAsync":        stale.DirSyncAsync(context.Background(), req, 0, 0, 0, nil).Err(),
+	}
+	_, calls["DirSync"] = stale.DirSync(req, 0, 0, nil)
+	for name, err := range calls {
+		if !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Expected ErrConnClosed from %s on a stale handle, got %v", name, err)
+		}
+	}
+	stale.SetTimeout(time.Nanosecond)
+
+	// The connection now lent out is unaffected
+	if _, err := conn.Search(req); err != nil {
+		t.Errorf("Search on the current handle failed: %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server := newFakeServer(t)
+
+	t.Run("Waits for borrowed connections", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+
+		conn, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		// A waiter is failed as soon as the shutdown starts
+		waitErr := make(chan error, 1)
+		go func() {
+			_, err := pool.GetConnection(context.Background())
+			waitErr <- err
+		}()
+		for pool.PoolStats().Waiters == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		done := make(chan struct{})
+		var forced int
+		go func() {
+			defer close(done)
+			forced, err = pool.Shutdown(context.Background())
+		}()
+
+		if err := <-waitErr; !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("Expected ErrPoolClosed for the waiter, got %v", err)
+		}
+		if _, err := pool.GetConnection(context.Background()); !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("Expected ErrPoolClosed after shutdown started, got %v", err)
+		}
+
+		select {
+		case <-done:
+			t.Fatal("Shutdown returned while a connection was borrowed")
+		case <-time.After(20 * time.Millisecond):
+		}
+		conn.Close()
+		<-done
+		if forced != 0 || err != nil {
+			t.Errorf("Expected a clean shutdown, got %d forced and %v", forced, err)
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected no open connections, got %d", open)
+		}
+		server.waitIdle()
+	})
+
+	t.Run("Forces connections after the deadline", func(t *testing.T) {
+		config := server.config()
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+
+		held := make([]*LdapConn, 2)
+		for i := range held {
+			if held[i], err = pool.GetConnection(context.Background()); err != nil {
+				t.Fatalf("Failed to get connection: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		forced, err := pool.Shutdown(ctx)
+		if forced != 2 || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected 2 forced connections and a deadline error, got %d and %v", forced, err)
+		}
+		if stats := pool.PoolStats(); stats.ForcedClosed != 2 {
+			t.Errorf("Expected 2 forced closes in stats, got %d", stats.ForcedClosed)
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected no open connections, got %d", open)
+		}
+		for _, conn := range held {
+			if err := conn.Close(); !errors.Is(err, ErrConnClosed) {
+				t.Errorf("Expected ErrConnClosed for a forced connection, got %v", err)
+			}
+		}
+		server.waitIdle()
+
+		if _, err := pool.Shutdown(context.Background()); !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("Expected ErrPoolClosed from a second shutdown, got %v", err)
+		}
+	})
+
+	t.Run("Requests racing the close are failed", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		for i := 0; i < 50; i++ {
+			pool, err := NewPool(config)
+			if err != nil {
+				t.Fatalf("Failed to create pool: %v", err)
+			}
+			held, err := pool.GetConnection(context.Background())
+			if err != nil {
+				t.Fatalf("Failed to get connection: %v", err)
+			}
+
+			// Without a free slot the request would wait for a wake-up that
+			// never comes once the pool is closed
+			waitErr := make(chan error, 1)
+			go func() {
+				_, err := pool.GetConnection(context.Background())
+				waitErr <- err
+			}()
+			pool.Close()
+			select {
+			case err := <-waitErr:
+				if !errors.Is(err, ErrPoolClosed) {
+					t.Fatalf("Expected ErrPoolClosed, got %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Request was stranded by a concurrent Close")
+			}
+			held.Close()
+		}
+	})
+}
+
+func TestWithConn(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	t.Run("Returns the connection", func(t *testing.T) {
+		var borrowed *LdapConn
+		err := pool.WithConn(ctx, func(conn *LdapConn) error {
+			borrowed = conn
+			_, err := conn.Search(ldap.NewSearchRequest(config.BaseDN, ldap.ScopeBaseObject,
+				ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := borrowed.Close(); !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Expected the connection to be returned, got %v", err)
+		}
+		if open, idle := pool.Stats(); open != 1 || idle != 1 {
+			t.Errorf("Expected 1 open idle connection, got %d open and %d idle", open, idle)
+		}
+	})
+
+	t.Run("Callback errors are passed through", func(t *testing.T) {
+		want := errors.New("boom")
+		if err := pool.WithConn(ctx, func(*LdapConn) error { return want }); err != want {
+			t.Errorf("Expected callback error, got %v", err)
+		}
+		if open, idle := pool.Stats(); open != 1 || idle != 1 {
+			t.Errorf("Expected the connection to be kept, got %d open and %d idle", open, idle)
+		}
+	})
+
+	t.Run("Network errors discard the connection", func(t *testing.T) {
+		err := pool.WithConn(ctx, func(*LdapConn) error {
+			return ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))
+		})
+		if !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			t.Errorf("Expected network error, got %v", err)
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected the connection to be discarded, got %d open", open)
+		}
+	})
+
+	t.Run("Panics are recovered", func(t *testing.T) {
+		err := pool.WithConn(ctx, func(*LdapConn) error { panic("boom") })
+		if err == nil || err.Error() != "panic in WithConn callback: boom" {
+			t.Errorf("Expected recovered panic, got %v", err)
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected the connection to be discarded, got %d open", open)
+		}
+
+		// The slot is free again
+		tctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := pool.WithConn(tctx, func(*LdapConn) error { return nil }); err != nil {
+			t.Errorf("Expected a connection after the panic, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package ldapool
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Checkout describes a connection that is currently borrowed from the pool
+type Checkout struct {
+	// url of the server the connection is dialed to
+	Server string
+	// when the connection was handed out
+	Since time.Time
+	// how long the connection has been held
+	Held time.Duration
+	// stack trace of the GetConnection call that borrowed the connection,
+	// only recorded when LeakThreshold is set
+	Stack string
+	// whether the connection has been held longer than LeakThreshold
+	Leaked bool
+}
+
+// trackCheckout records when conn was borrowed and, when leak detection is
+// enabled, where
+func (lcp *LdapConnPool) trackCheckout(conn *LdapConn) {
+	var stack []byte
+	if lcp.config.LeakThreshold > 0 {
+		stack = debug.Stack()
+	}
+
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	conn.checkedOutAt = time.Now()
+	conn.checkoutStack = stack
+	lcp.borrowed[conn] = struct{}{}
+}
+
+// untrackCheckout forgets conn once it is returned
+func (lcp *LdapConnPool) untrackCheckout(conn *LdapConn) {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+	delete(lcp.borrowed, conn)
+}
+
+// checkLeaks reports connections held longer than LeakThreshold, closing
+// them and freeing their slots when ReclaimLeaked is set
+func (lcp *LdapConnPool) checkLeaks() {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+
+	for conn := range lcp.borrowed {
+		held := time.Since(conn.checkedOutAt)
+		if held <= lcp.config.LeakThreshold {
+			continue
+		}
+		if !conn.leakReported {
+			conn.leakReported = true
+			lcp.logger.Warn("ldap connection leak suspected", slog.String("server", redactURL(conn.server)),
+				slog.Duration("held", held), slog.Bool("reclaimed", lcp.config.ReclaimLeaked),
+				slog.String("stack", string(conn.checkoutStack)))
+		}
+		if lcp.config.ReclaimLeaked {
+			lcp.forceCloseLocked(conn, closeLeaked)
+		}
+	}
+}
+
+// forceCloseLocked takes a borrowed handle back from its holder and closes
+// its connection, reporting whether it did. Must be called with lcp.mu held.
+func (lcp *LdapConnPool) forceCloseLocked(handle *LdapConn, reason closeReason) bool {
+	// The caller may be returning it right now, in which case it is theirs to release
+	if !atomic.CompareAndSwapInt32(&handle.returned, 0, 1) {
+		return false
+	}
+	delete(lcp.borrowed, handle)
+	lcp.closeConnLocked(handle.pooled, reason)
+	return true
+}
+
+// CheckedOut returns the connections currently borrowed from the pool,
+// longest held first
+func (lcp *LdapConnPool) CheckedOut() []Checkout {
+	lcp.mu.Lock()
+	defer lcp.mu.Unlock()
+
+	now := time.Now()
+	checkouts := make([]Checkout, 0, len(lcp.borrowed))
+	for conn := range lcp.borrowed {
+		held := now.Sub(conn.checkedOutAt)
+		checkouts = append(checkouts, Checkout{
+			Server: conn.server,
+			Since:  conn.checkedOutAt,
+			Held:   held,
+			Stack:  string(conn.checkoutStack),
+			Leaked: lcp.config.LeakThreshold > 0 && held > lcp.config.LeakThreshold,
+		})
+	}
+	sort.Slice(checkouts, func(i, j int) bool {
+		return checkouts[i].Since.Before(checkouts[j].Since)
+	})
+	return checkouts
+}
+
+// DumpCheckedOut writes a human readable report of the borrowed connections
+// and where they were borrowed, for debugging leaks
+func (lcp *LdapConnPool) DumpCheckedOut(w io.Writer) error {
+	checkouts := lcp.CheckedOut()
+	if _, err := fmt.Fprintf(w, "%d connection(s) checked out\n", len(checkouts)); err != nil {
+		return err
+	}
+	for _, c := range checkouts {
+		status := ""
+		if c.Leaked {
+			status = " LEAKED"
+		}
+		if _, err := fmt.Fprintf(w, "\n%s held %s since %s%s\n%s",
+			redactURL(c.Server), c.Held.Round(time.Millisecond), c.Since.Format(time.RFC3339), status, c.Stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
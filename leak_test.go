@@ -0,0 +1,89 @@
+package ldapool
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeakDetection(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	config.LeakThreshold = time.Hour
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+
+	checkouts := pool.CheckedOut()
+	if len(checkouts) != 1 {
+		t.Fatalf("Expected one checkout, got %d", len(checkouts))
+	}
+	if checkouts[0].Server != server.url || checkouts[0].Leaked {
+		t.Errorf("Unexpected checkout %+v", checkouts[0])
+	}
+	if !strings.Contains(checkouts[0].Stack, "TestLeakDetection") {
+		t.Errorf("Expected the checkout stack to show the caller, got:\n%s", checkouts[0].Stack)
+	}
+
+	var buf bytes.Buffer
+	if err := pool.DumpCheckedOut(&buf); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "1 connection(s) checked out") || !strings.Contains(buf.String(), "TestLeakDetection") {
+		t.Errorf("Unexpected dump:\n%s", buf.String())
+	}
+
+	conn.Close()
+	if n := len(pool.CheckedOut()); n != 0 {
+		t.Errorf("Expected no checkouts after Close, got %d", n)
+	}
+}
+
+func TestLeakReclaim(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	config.MaxOpen = 1
+	config.LeakThreshold = 20 * time.Millisecond
+	config.ReclaimLeaked = true
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	leaked, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+
+	// The pool is saturated until the leaked connection is reclaimed
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := pool.GetConnection(ctx)
+	if err != nil {
+		t.Fatalf("Expected the leaked slot to be reclaimed: %v", err)
+	}
+	if conn.pooled == leaked.pooled {
+		t.Fatal("Expected a new connection")
+	}
+	if n := pool.PoolStats().LeakedClosed; n != 1 {
+		t.Errorf("Expected one reclaimed connection, got %d", n)
+	}
+
+	// Closing the reclaimed connection late does not free a second slot
+	leaked.Close()
+	if stats := pool.PoolStats(); stats.Open != 1 || stats.InUse != 1 {
+		t.Errorf("Expected 1 open connection in use, got %d open and %d in use", stats.Open, stats.InUse)
+	}
+	conn.Close()
+}
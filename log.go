@@ -0,0 +1,78 @@
+package ldapool
+
+import (
+	"log/slog"
+	"net/url"
+)
+
+// redacted replaces secrets in log output
+const redacted = "[REDACTED]"
+
+// newLogger returns the logger for config, a discarding one when logging is off
+func newLogger(config LdapConfig) *slog.Logger {
+	if config.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	if config.Name != "" {
+		return config.Logger.With(slog.String("pool", config.Name))
+	}
+	return config.Logger
+}
+
+// LogValue implements slog.LogValuer so a logged configuration never
+// contains the admin password or credentials embedded in server URLs
+func (config LdapConfig) LogValue() slog.Value {
+	var urls []string
+	if config.Url != "" || len(config.Urls) > 0 {
+		for _, u := range config.serverURLs() {
+			urls = append(urls, redactURL(u))
+		}
+	}
+	attrs := []slog.Attr{
+		slog.String("name", config.Name),
+		slog.Any("urls", urls),
+		slog.String("srv_domain", config.SRVDomain),
+		slog.String("base_dn", config.BaseDN),
+		slog.String("admin_dn", config.AdminDN),
+		slog.String("admin_pass", redacted),
+		slog.Int("max_open", config.MaxOpen),
+		slog.Int("max_idle", config.MaxIdle),
+		slog.Int("min_idle", config.MinIdle),
+		slog.Bool("start_tls", config.UseStartTLS),
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// redactURL hides the password of a server URL with user info
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// String returns the reason as logged when a connection is closed
+func (r closeReason) String() string {
+	switch r {
+	case closeBroken:
+		return "broken"
+	case closeMaxLifetime:
+		return "max_lifetime"
+	case closeMaxIdleTime:
+		return "max_idle_time"
+	case closeMaxIdle:
+		return "max_idle"
+	case closeDrained:
+		return "drained"
+	case closeRejected:
+		return "rejected"
+	case closePoolClosed:
+		return "pool_closed"
+	case closeLeaked:
+		return "leaked"
+	case closeForced:
+		return "forced"
+	}
+	return "none"
+}
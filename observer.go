@@ -0,0 +1,64 @@
+package ldapool
+
+import "time"
+
+// Observer is notified of timed pool events, eg. to feed latency metrics.
+// Methods are called synchronously and must not block.
+type Observer interface {
+	// ObserveAcquire is called when GetConnection returns
+	ObserveAcquire(d time.Duration, err error)
+	// ObserveDial is called after connecting to server, including StartTLS.
+	// Any password in the server url is redacted.
+	ObserveDial(server string, d time.Duration, err error)
+	// ObserveBind is called after binding to server, its url redacted as for
+	// ObserveDial
+	ObserveBind(server string, d time.Duration, err error)
+}
+
+// AddObserver registers o to be notified of pool events
+func (lcp *LdapConnPool) AddObserver(o Observer) {
+	lcp.observerMu.Lock()
+	defer lcp.observerMu.Unlock()
+	observers := make([]Observer, len(lcp.observers), len(lcp.observers)+1)
+	copy(observers, lcp.observers)
+	lcp.observers = append(observers, o)
+}
+
+// getObservers returns the registered observers. The slice is never modified
+// in place, so it can be iterated without holding the lock.
+func (lcp *LdapConnPool) getObservers() []Observer {
+	lcp.observerMu.RLock()
+	defer lcp.observerMu.RUnlock()
+	return lcp.observers
+}
+
+// observeAcquire notifies observers of a GetConnection call started at start
+func (lcp *LdapConnPool) observeAcquire(start time.Time, err error) {
+	for _, o := range lcp.getObservers() {
+		o.ObserveAcquire(time.Since(start), err)
+	}
+}
+
+// observeDial notifies observers of a dial to server started at start
+func (lcp *LdapConnPool) observeDial(server string, start time.Time, err error) {
+	observers := lcp.getObservers()
+	if len(observers) == 0 {
+		return
+	}
+	d, server := time.Since(start), redactURL(server)
+	for _, o := range observers {
+		o.ObserveDial(server, d, err)
+	}
+}
+
+// observeBind notifies observers of a bind to server started at start
+func (lcp *LdapConnPool) observeBind(server string, start time.Time, err error) {
+	observers := lcp.getObservers()
+	if len(observers) == 0 {
+		return
+	}
+	d, server := time.Since(start), redactURL(server)
+	for _, o := range observers {
+		o.ObserveBind(server, d, err)
+	}
+}
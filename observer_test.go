@@ -0,0 +1,78 @@
+package ldapool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver counts the events it is notified of
+type recordingObserver struct {
+	mu            sync.Mutex
+	acquires      int
+	acquireErrors int
+	dials         []string
+	binds         []string
+	bindErrors    int
+}
+
+func (o *recordingObserver) ObserveAcquire(d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.acquires++
+	if err != nil {
+		o.acquireErrors++
+	}
+}
+
+func (o *recordingObserver) ObserveDial(server string, d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dials = append(o.dials, server)
+}
+
+func (o *recordingObserver) ObserveBind(server string, d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.binds = append(o.binds, server)
+	if err != nil {
+		o.bindErrors++
+	}
+}
+
+func TestObserver(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	o := &recordingObserver{}
+	pool.AddObserver(o)
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	// While the first connection is held the next call dials again and fails to bind
+	server.setPassword("changed")
+	defer server.setPassword(config.AdminPass)
+	if _, err := pool.GetConnection(context.Background()); err == nil {
+		t.Fatal("Expected bind failure")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.acquires != 2 || o.acquireErrors != 1 {
+		t.Errorf("Expected 2 acquires with 1 error, got %d with %d errors", o.acquires, o.acquireErrors)
+	}
+	if len(o.dials) != 2 || o.dials[0] != server.url || len(o.binds) != 2 || o.bindErrors != 1 {
+		t.Errorf("Expected two dials and binds to %s with one bind error, got %v, %v and %d errors",
+			server.url, o.dials, o.binds, o.bindErrors)
+	}
+}
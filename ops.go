@@ -0,0 +1,371 @@
+package ldapool
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The methods below shadow the ones promoted from the embedded *ldap.Conn so
+// every operation issued through the pool is instrumented, and fails with
+// ErrConnClosed once the connection has been returned
+
+// startOp starts the span of an LDAP operation as a child of ctx, which is
+// the context the connection was borrowed with unless the operation has its own
+func (lc *LdapConn) startOp(ctx context.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if lc.pool == nil {
+		// A non-recording span that is safe to end
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := lc.pool.startSpan(ctx, name, append(attrs, attrServer.String(lc.server))...)
+	return span
+}
+
+// Search performs the given search request
+func (lc *LdapConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.search", attrBaseDN.String(req.BaseDN), attrScope.String(ldap.ScopeMap[req.Scope]))
+	result, err := lc.Conn.Search(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// SearchWithPaging performs the given search request with paging
+func (lc *LdapConn) SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.search", attrBaseDN.String(req.BaseDN), attrScope.String(ldap.ScopeMap[req.Scope]))
+	result, err := lc.Conn.SearchWithPaging(req, pagingSize)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// Add performs the given add request
+func (lc *LdapConn) Add(req *ldap.AddRequest) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.add", attrDN.String(req.DN))
+	err := lc.Conn.Add(req)
+	endOpSpan(span, err)
+	return err
+}
+
+// Modify performs the given modify request
+func (lc *LdapConn) Modify(req *ldap.ModifyRequest) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.modify", attrDN.String(req.DN))
+	err := lc.Conn.Modify(req)
+	endOpSpan(span, err)
+	return err
+}
+
+// ModifyDN renames or moves the entry of the given request
+func (lc *LdapConn) ModifyDN(req *ldap.ModifyDNRequest) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.modify_dn", attrDN.String(req.DN))
+	err := lc.Conn.ModifyDN(req)
+	endOpSpan(span, err)
+	return err
+}
+
+// Del performs the given delete request
+func (lc *LdapConn) Del(req *ldap.DelRequest) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.delete", attrDN.String(req.DN))
+	err := lc.Conn.Del(req)
+	endOpSpan(span, err)
+	return err
+}
+
+// Compare checks whether the attribute of dn has the given value
+func (lc *LdapConn) Compare(dn, attribute, value string) (bool, error) {
+	if err := lc.usable(); err != nil {
+		return false, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.compare", attrDN.String(dn))
+	ok, err := lc.Conn.Compare(dn, attribute, value)
+	endOpSpan(span, err)
+	return ok, err
+}
+
+// PasswordModify performs the given password modify extended operation
+func (lc *LdapConn) PasswordModify(req *ldap.PasswordModifyRequest) (*ldap.PasswordModifyResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.password_modify", attrDN.String(req.UserIdentity))
+	result, err := lc.Conn.PasswordModify(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// ModifyWithResult performs the given modify request and returns its result
+func (lc *LdapConn) ModifyWithResult(req *ldap.ModifyRequest) (*ldap.ModifyResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.modify", attrDN.String(req.DN))
+	result, err := lc.Conn.ModifyWithResult(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// Extended performs the given extended operation
+func (lc *LdapConn) Extended(req *ldap.ExtendedRequest) (*ldap.ExtendedResponse, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.extended")
+	result, err := lc.Conn.Extended(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// WhoAmI returns the authorization identity of the connection
+func (lc *LdapConn) WhoAmI(controls []ldap.Control) (*ldap.WhoAmIResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.whoami")
+	result, err := lc.Conn.WhoAmI(controls)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// Bind authenticates the connection as username
+func (lc *LdapConn) Bind(username, password string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.Bind(username, password)
+	endOpSpan(span, err)
+	return err
+}
+
+// SimpleBind performs the given simple bind request
+func (lc *LdapConn) SimpleBind(req *ldap.SimpleBindRequest) (*ldap.SimpleBindResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(req.Username))
+	result, err := lc.Conn.SimpleBind(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// Unbind unbinds and closes the connection
+func (lc *LdapConn) Unbind() error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	return lc.Conn.Unbind()
+}
+
+// SetTimeout sets the request timeout of the connection. It does nothing once
+// the connection has been returned.
+func (lc *LdapConn) SetTimeout(timeout time.Duration) {
+	if lc.usable() != nil {
+		return
+	}
+	lc.Conn.SetTimeout(timeout)
+}
+
+// Start starts the connection's message processing. It does nothing once the
+// connection has been returned.
+func (lc *LdapConn) Start() {
+	if lc.usable() != nil {
+		return
+	}
+	lc.Conn.Start()
+}
+
+// errResponse is the ldap.Response of an asynchronous search that could not
+// be started
+type errResponse struct {
+	err error
+}
+
+func (r errResponse) Entry() *ldap.Entry       { return nil }
+func (r errResponse) Referral() string         { return "" }
+func (r errResponse) Controls() []ldap.Control { return nil }
+func (r errResponse) Err() error               { return r.err }
+func (r errResponse) Next() bool               { return false }
+
+// SearchAsync performs the given search request asynchronously
+func (lc *LdapConn) SearchAsync(ctx context.Context, req *ldap.SearchRequest, bufferSize int) ldap.Response {
+	if err := lc.usable(); err != nil {
+		return errResponse{err}
+	}
+	return lc.Conn.SearchAsync(ctx, req, bufferSize)
+}
+
+// Syncrepl performs the given search request as a content synchronization
+// (RFC 4533) search
+func (lc *LdapConn) Syncrepl(ctx context.Context, req *ldap.SearchRequest, bufferSize int,
+	mode ldap.ControlSyncRequestMode, cookie []byte, reloadHint bool) ldap.Response {
+	if err := lc.usable(); err != nil {
+		return errResponse{err}
+	}
+	return lc.Conn.Syncrepl(ctx, req, bufferSize, mode, cookie, reloadHint)
+}
+
+// DirSync performs the given search request with the Active Directory
+// DirSync control
+func (lc *LdapConn) DirSync(req *ldap.SearchRequest, flags, maxAttrCount int64, cookie []byte) (*ldap.SearchResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.search", attrBaseDN.String(req.BaseDN), attrScope.String(ldap.ScopeMap[req.Scope]))
+	result, err := lc.Conn.DirSync(req, flags, maxAttrCount, cookie)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// DirSyncAsync performs the given search request with the Active Directory
+// DirSync control asynchronously
+func (lc *LdapConn) DirSyncAsync(ctx context.Context, req *ldap.SearchRequest, bufferSize int,
+	flags, maxAttrCount int64, cookie []byte) ldap.Response {
+	if err := lc.usable(); err != nil {
+		return errResponse{err}
+	}
+	return lc.Conn.DirSyncAsync(ctx, req, bufferSize, flags, maxAttrCount, cookie)
+}
+
+// UnauthenticatedBind performs an unauthenticated bind as username
+func (lc *LdapConn) UnauthenticatedBind(username string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.UnauthenticatedBind(username)
+	endOpSpan(span, err)
+	return err
+}
+
+// ExternalBind performs a SASL EXTERNAL bind
+func (lc *LdapConn) ExternalBind() error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind")
+	err := lc.Conn.ExternalBind()
+	endOpSpan(span, err)
+	return err
+}
+
+// MD5Bind performs a SASL DIGEST-MD5 bind as username
+func (lc *LdapConn) MD5Bind(host, username, password string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.MD5Bind(host, username, password)
+	endOpSpan(span, err)
+	return err
+}
+
+// DigestMD5Bind performs the given SASL DIGEST-MD5 bind request
+func (lc *LdapConn) DigestMD5Bind(req *ldap.DigestMD5BindRequest) (*ldap.DigestMD5BindResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(req.Username))
+	result, err := lc.Conn.DigestMD5Bind(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// NTLMBind performs an NTLM bind as username
+func (lc *LdapConn) NTLMBind(domain, username, password string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.NTLMBind(domain, username, password)
+	endOpSpan(span, err)
+	return err
+}
+
+// NTLMBindWithHash performs an NTLM bind as username with a password hash
+func (lc *LdapConn) NTLMBindWithHash(domain, username, hash string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.NTLMBindWithHash(domain, username, hash)
+	endOpSpan(span, err)
+	return err
+}
+
+// NTLMUnauthenticatedBind performs an unauthenticated NTLM bind as username
+func (lc *LdapConn) NTLMUnauthenticatedBind(domain, username string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(username))
+	err := lc.Conn.NTLMUnauthenticatedBind(domain, username)
+	endOpSpan(span, err)
+	return err
+}
+
+// NTLMChallengeBind performs the given NTLM bind request
+func (lc *LdapConn) NTLMChallengeBind(req *ldap.NTLMBindRequest) (*ldap.NTLMBindResult, error) {
+	if err := lc.usable(); err != nil {
+		return nil, err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind", attrDN.String(req.Username))
+	result, err := lc.Conn.NTLMChallengeBind(req)
+	endOpSpan(span, err)
+	return result, err
+}
+
+// GSSAPIBind performs a SASL GSSAPI (Kerberos) bind for servicePrincipal
+func (lc *LdapConn) GSSAPIBind(client ldap.GSSAPIClient, servicePrincipal, authzid string) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind")
+	err := lc.Conn.GSSAPIBind(client, servicePrincipal, authzid)
+	endOpSpan(span, err)
+	return err
+}
+
+// GSSAPIBindRequest performs the given SASL GSSAPI bind request
+func (lc *LdapConn) GSSAPIBindRequest(client ldap.GSSAPIClient, req *ldap.GSSAPIBindRequest) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind")
+	err := lc.Conn.GSSAPIBindRequest(client, req)
+	endOpSpan(span, err)
+	return err
+}
+
+// GSSAPIBindRequestWithAPOptions performs the given SASL GSSAPI bind request
+// with the given AP options
+func (lc *LdapConn) GSSAPIBindRequestWithAPOptions(client ldap.GSSAPIClient, req *ldap.GSSAPIBindRequest, apOptions []int) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	span := lc.startOp(lc.ctx, "ldap.bind")
+	err := lc.Conn.GSSAPIBindRequestWithAPOptions(client, req, apOptions)
+	endOpSpan(span, err)
+	return err
+}
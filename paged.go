@@ -0,0 +1,79 @@
+package ldapool
+
+import (
+	"context"
+	"iter"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// SearchPaged runs req with the simple paged results control, requesting
+// pageSize entries at a time, and yields the entries one by one. A search
+// error ends the iteration after being yielded with a nil entry.
+//
+// One pooled connection is held while the pages are fetched, since servers
+// tie the paging cookie to the connection, and returned to the pool once the
+// iteration ends, including when the loop stops early.
+//
+// Like ldap.Conn.SearchWithPaging, SearchPaged keeps the paging control in
+// req.Controls up to date. After each complete page its cookie points at the
+// next one, so running req again resumes the search: from the page that was
+// in progress when the loop stopped early, or from a cookie set by the caller
+// with ldap.NewControlPaging and SetCookie. Whether a cookie can be used on
+// another connection depends on the server; Active Directory allows it.
+func (lcp *LdapConnPool) SearchPaged(ctx context.Context, req *ldap.SearchRequest, pageSize uint32) iter.Seq2[*ldap.Entry, error] {
+	return func(yield func(*ldap.Entry, error) bool) {
+		paging := pagingControl(req, pageSize)
+
+		conn, err := lcp.GetConnection(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		stale := false
+		defer func() {
+			if stale {
+				lcp.discard(conn)
+			} else {
+				conn.Close()
+			}
+		}()
+
+		for {
+			result, err := conn.SearchContext(ctx, req)
+			if err != nil {
+				stale = isStale(conn, err)
+				yield(nil, err)
+				return
+			}
+			for _, entry := range result.Entries {
+				if !yield(entry, nil) {
+					return
+				}
+			}
+
+			// A server without paging support returns everything at once
+			next, ok := ldap.FindControl(result.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+			if !ok {
+				paging.SetCookie(nil)
+				return
+			}
+			paging.SetCookie(next.Cookie)
+			if len(next.Cookie) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// pagingControl returns the paging control of req, adding one if it has none,
+// set to request pageSize entries
+func pagingControl(req *ldap.SearchRequest, pageSize uint32) *ldap.ControlPaging {
+	if paging, ok := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		paging.PagingSize = pageSize
+		return paging
+	}
+	paging := ldap.NewControlPaging(pageSize)
+	req.Controls = append(req.Controls, paging)
+	return paging
+}
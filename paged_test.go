@@ -0,0 +1,87 @@
+package ldapool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestSearchPaged(t *testing.T) {
+	server := newFakeServer(t)
+	server.setEntries(25)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	searches := server.Requests(ldap.ApplicationSearchRequest)
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	var dns []string
+	for entry, err := range pool.SearchPaged(context.Background(), req, 10) {
+		if err != nil {
+			t.Fatalf("Paged search failed: %v", err)
+		}
+		if stats := pool.PoolStats(); stats.InUse != 1 {
+			t.Errorf("Expected one connection in use while paging, got %d", stats.InUse)
+		}
+		dns = append(dns, entry.DN)
+	}
+
+	if len(dns) != 25 || dns[0] != "cn=user0,"+config.BaseDN || dns[24] != "cn=user24,"+config.BaseDN {
+		t.Errorf("Unexpected entries %v", dns)
+	}
+	if n := server.Requests(ldap.ApplicationSearchRequest) - searches; n != 3 {
+		t.Errorf("Expected 3 pages, got %d", n)
+	}
+	if stats := pool.PoolStats(); stats.InUse != 0 {
+		t.Errorf("Expected the connection to be returned, got %d in use", stats.InUse)
+	}
+}
+
+func TestSearchPagedResume(t *testing.T) {
+	server := newFakeServer(t)
+	server.setEntries(25)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	seen := 0
+	for _, err := range pool.SearchPaged(context.Background(), req, 10) {
+		if err != nil {
+			t.Fatalf("Paged search failed: %v", err)
+		}
+		if seen++; seen == 12 {
+			break
+		}
+	}
+	if stats := pool.PoolStats(); stats.InUse != 0 {
+		t.Errorf("Expected the connection to be returned after stopping early, got %d in use", stats.InUse)
+	}
+
+	// The cookie points at the page that was in progress
+	paging := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if string(paging.Cookie) != "10" {
+		t.Fatalf("Expected the cookie of the second page, got %q", paging.Cookie)
+	}
+
+	var dns []string
+	for entry, err := range pool.SearchPaged(context.Background(), req, 10) {
+		if err != nil {
+			t.Fatalf("Resumed search failed: %v", err)
+		}
+		dns = append(dns, entry.DN)
+	}
+	if len(dns) != 15 || dns[0] != fmt.Sprintf("cn=user10,%s", config.BaseDN) {
+		t.Errorf("Expected to resume at the second page, got %v", dns)
+	}
+}
@@ -0,0 +1,168 @@
+// Package prometheus exports ldapool connection pool metrics to Prometheus.
+//
+// Every metric carries the pool label given to NewCollector. The gauges and
+// lifetime counters read from PoolStats are per pool only; the dial and bind
+// latencies and dial_errors_total are also labelled with the server, whose
+// url has any password redacted.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/eryajf/ldapool"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "ldapool"
+
+// Collector is a prometheus.Collector for an ldapool.LdapConnPool. Gauges and
+// lifetime counters are read from PoolStats on every scrape; latencies are
+// recorded as the pool reports them.
+type Collector struct {
+	pool *ldapool.LdapConnPool
+
+	maxOpen      *prom.Desc
+	open         *prom.Desc
+	inUse        *prom.Desc
+	idle         *prom.Desc
+	waiters      *prom.Desc
+	dials        *prom.Desc
+	waitCount    *prom.Desc
+	waitDuration *prom.Desc
+	evictions    *prom.Desc
+
+	acquire       prom.Histogram
+	acquireErrors prom.Counter
+	dial          *prom.HistogramVec
+	bind          *prom.HistogramVec
+	dialErrors    *prom.CounterVec
+}
+
+// NewCollector creates a collector for pool, labelling every metric with
+// name. The collector starts observing the pool right away; register it with
+// a prometheus.Registerer to export the metrics.
+func NewCollector(pool *ldapool.LdapConnPool, name string) *Collector {
+	labels := prom.Labels{"pool": name}
+	desc := func(metric, help string, variableLabels ...string) *prom.Desc {
+		return prom.NewDesc(prom.BuildFQName(namespace, "", metric), help, variableLabels, labels)
+	}
+
+	c := &Collector{
+		pool:         pool,
+		maxOpen:      desc("max_open_connections", "Maximum number of open connections."),
+		open:         desc("open_connections", "Number of established connections, both in use and idle."),
+		inUse:        desc("in_use_connections", "Number of connections currently borrowed or being dialed."),
+		idle:         desc("idle_connections", "Number of idle connections."),
+		waiters:      desc("waiters", "Number of requests waiting for a connection."),
+		dials:        desc("dials_total", "Total number of connections opened."),
+		waitCount:    desc("wait_count_total", "Total number of requests that had to wait for a connection."),
+		waitDuration: desc("wait_duration_seconds_total", "Total time spent waiting for connections."),
+		evictions:    desc("evictions_total", "Total number of connections closed by the pool.", "reason"),
+		acquire: prom.NewHistogram(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "acquire_duration_seconds",
+			Help:        "Time taken by GetConnection, including time spent waiting.",
+			ConstLabels: labels,
+			Buckets:     prom.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		acquireErrors: prom.NewCounter(prom.CounterOpts{
+			Namespace:   namespace,
+			Name:        "acquire_errors_total",
+			Help:        "Total number of GetConnection calls that returned an error.",
+			ConstLabels: labels,
+		}),
+		dial: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "dial_duration_seconds",
+			Help:        "Time taken to connect to a server, including StartTLS.",
+			ConstLabels: labels,
+			Buckets:     prom.DefBuckets,
+		}, []string{"server"}),
+		bind: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "bind_duration_seconds",
+			Help:        "Time taken to bind to a server.",
+			ConstLabels: labels,
+			Buckets:     prom.DefBuckets,
+		}, []string{"server"}),
+		dialErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace:   namespace,
+			Name:        "dial_errors_total",
+			Help:        "Total number of failed connection attempts by stage (dial or bind).",
+			ConstLabels: labels,
+		}, []string{"server", "stage"}),
+	}
+	pool.AddObserver(c)
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waiters
+	ch <- c.dials
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.evictions
+	c.acquire.Describe(ch)
+	c.acquireErrors.Describe(ch)
+	c.dial.Describe(ch)
+	c.bind.Describe(ch)
+	c.dialErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	stats := c.pool.PoolStats()
+
+	ch <- prom.MustNewConstMetric(c.maxOpen, prom.GaugeValue, float64(stats.MaxOpen))
+	ch <- prom.MustNewConstMetric(c.open, prom.GaugeValue, float64(stats.Open))
+	ch <- prom.MustNewConstMetric(c.inUse, prom.GaugeValue, float64(stats.InUse))
+	ch <- prom.MustNewConstMetric(c.idle, prom.GaugeValue, float64(stats.Idle))
+	ch <- prom.MustNewConstMetric(c.waiters, prom.GaugeValue, float64(stats.Waiters))
+	ch <- prom.MustNewConstMetric(c.dials, prom.CounterValue, float64(stats.Dials))
+	ch <- prom.MustNewConstMetric(c.waitCount, prom.CounterValue, float64(stats.WaitCount))
+	ch <- prom.MustNewConstMetric(c.waitDuration, prom.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.BrokenClosed), "broken")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.LeakedClosed), "leaked")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.DrainedClosed), "drained")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.RejectedClosed), "rejected")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.ShutdownClosed), "pool_closed")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.ForcedClosed), "forced")
+
+	c.acquire.Collect(ch)
+	c.acquireErrors.Collect(ch)
+	c.dial.Collect(ch)
+	c.bind.Collect(ch)
+	c.dialErrors.Collect(ch)
+}
+
+// ObserveAcquire implements ldapool.Observer
+func (c *Collector) ObserveAcquire(d time.Duration, err error) {
+	c.acquire.Observe(d.Seconds())
+	if err != nil {
+		c.acquireErrors.Inc()
+	}
+}
+
+// ObserveDial implements ldapool.Observer
+func (c *Collector) ObserveDial(server string, d time.Duration, err error) {
+	c.dial.WithLabelValues(server).Observe(d.Seconds())
+	if err != nil {
+		c.dialErrors.WithLabelValues(server, "dial").Inc()
+	}
+}
+
+// ObserveBind implements ldapool.Observer
+func (c *Collector) ObserveBind(server string, d time.Duration, err error) {
+	c.bind.WithLabelValues(server).Observe(d.Seconds())
+	if err != nil {
+		c.dialErrors.WithLabelValues(server, "bind").Inc()
+	}
+}
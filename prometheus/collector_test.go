@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eryajf/ldapool"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newUnreachablePool creates a lazy pool whose server refuses connections
+func newUnreachablePool(t *testing.T) (*ldapool.LdapConnPool, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	url := "ldap://" + ln.Addr().String()
+	ln.Close()
+
+	pool, err := ldapool.NewPool(ldapool.LdapConfig{
+		Url:         url,
+		AdminDN:     "cn=admin,dc=eryajf,dc=net",
+		AdminPass:   "123456",
+		MaxOpen:     3,
+		ConnTimeout: time.Second,
+		LazyConnect: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool, url
+}
+
+func TestCollector(t *testing.T) {
+	pool, url := newUnreachablePool(t)
+	c := NewCollector(pool, "corp")
+
+	reg := prom.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Failed to register collector: %v", err)
+	}
+
+	if _, err := pool.GetConnection(context.Background()); err == nil {
+		t.Fatal("Expected GetConnection to fail")
+	}
+
+	expected := `
+# HELP ldapool_max_open_connections Maximum number of open connections.
+# TYPE ldapool_max_open_connections gauge
+ldapool_max_open_connections{pool="corp"} 3
+# HELP ldapool_open_connections Number of established connections, both in use and idle.
+# TYPE ldapool_open_connections gauge
+ldapool_open_connections{pool="corp"} 0
+# HELP ldapool_acquire_errors_total Total number of GetConnection calls that returned an error.
+# TYPE ldapool_acquire_errors_total counter
+ldapool_acquire_errors_total{pool="corp"} 1
+# HELP ldapool_dial_errors_total Total number of failed connection attempts by stage (dial or bind).
+# TYPE ldapool_dial_errors_total counter
+ldapool_dial_errors_total{pool="corp",server="` + url + `",stage="dial"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"ldapool_max_open_connections", "ldapool_open_connections",
+		"ldapool_acquire_errors_total", "ldapool_dial_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c, "ldapool_dial_duration_seconds"); n != 1 {
+		t.Errorf("Expected one dial latency series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(c, "ldapool_evictions_total"); n != 9 {
+		t.Errorf("Expected one eviction series per reason, got %d", n)
+	}
+	if n := testutil.CollectAndCount(c, "ldapool_bind_duration_seconds"); n != 0 {
+		t.Errorf("Expected no bind latency without a connection, got %d", n)
+	}
+}
+
+func TestCollectorRedactsServer(t *testing.T) {
+	_, url := newUnreachablePool(t)
+	// Point a second pool at the same closed port, with credentials in the url
+	secret := strings.Replace(url, "ldap://", "ldap://admin:secret@", 1)
+	pool, err := ldapool.NewPool(ldapool.LdapConfig{
+		Url:         secret,
+		AdminDN:     "cn=admin,dc=eryajf,dc=net",
+		AdminPass:   "123456",
+		MaxOpen:     1,
+		ConnTimeout: time.Second,
+		LazyConnect: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+	c := NewCollector(pool, "corp")
+
+	reg := prom.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Failed to register collector: %v", err)
+	}
+	if _, err := pool.GetConnection(context.Background()); err == nil {
+		t.Fatal("Expected GetConnection to fail")
+	}
+
+	redacted := strings.Replace(url, "ldap://", "ldap://admin:xxxxx@", 1)
+	expected := `
+# HELP ldapool_dial_errors_total Total number of failed connection attempts by stage (dial or bind).
+# TYPE ldapool_dial_errors_total counter
+ldapool_dial_errors_total{pool="corp",server="` + redacted + `",stage="dial"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "ldapool_dial_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorsForSeveralPools(t *testing.T) {
+	corp, _ := newUnreachablePool(t)
+	customer, _ := newUnreachablePool(t)
+
+	reg := prom.NewPedanticRegistry()
+	if err := reg.Register(NewCollector(corp, "corp")); err != nil {
+		t.Fatalf("Failed to register corp collector: %v", err)
+	}
+	if err := reg.Register(NewCollector(customer, "customer")); err != nil {
+		t.Fatalf("Failed to register customer collector: %v", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("Failed to gather: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrPoolNotRegistered = errors.New("connection pool is not registered")
+	ErrPoolRegistered    = errors.New("connection pool is already registered with a different configuration")
+)
+
+// registryEntry is a pool shared by every name registered with its config
+type registryEntry struct {
+	config LdapConfig
+	pool   *LdapConnPool
+	refs   int
+	// ready is closed once the pool has been created, or err has been set
+	ready chan struct{}
+	err   error
+}
+
+// wait blocks until the entry's pool has been created and returns the error
+// that prevented it, if any
+func (e *registryEntry) wait() error {
+	<-e.ready
+	return e.err
+}
+
+// Registry manages named connection pools. Names registered with identical
+// configurations share a single pool.
+type Registry struct {
+	mu    sync.Mutex
+	pools map[string]*registryEntry
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{pools: make(map[string]*registryEntry)}
+}
+
+// defaultRegistry backs the package level Register, Get and CloseAll
+var defaultRegistry = NewRegistry()
+
+// Register creates a pool for config under name. Registering a name again
+// with the same configuration is a no-op. The pool is created without holding
+// the registry lock, so other names stay usable meanwhile.
+func (r *Registry) Register(name string, config LdapConfig) error {
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	// Compare configurations the way the pool will see them
+	setDefaults(&config)
+
+	r.mu.Lock()
+	if entry, ok := r.pools[name]; ok {
+		r.mu.Unlock()
+		if reflect.DeepEqual(entry.config, config) {
+			return entry.wait()
+		}
+		return fmt.Errorf("%w: %s", ErrPoolRegistered, name)
+	}
+
+	for _, entry := range r.pools {
+		if reflect.DeepEqual(entry.config, config) {
+			entry.refs++
+			r.pools[name] = entry
+			r.mu.Unlock()
+			return entry.wait()
+		}
+	}
+
+	// Reserve the name while the pool dials
+	entry := &registryEntry{config: config, refs: 1, ready: make(chan struct{})}
+	r.pools[name] = entry
+	r.mu.Unlock()
+
+	pool, err := NewPool(config)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.pool, entry.err = pool, err
+	if err != nil {
+		for n, e := range r.pools {
+			if e == entry {
+				delete(r.pools, n)
+			}
+		}
+	}
+	close(entry.ready)
+	return err
+}
+
+// lookup returns the entry registered under name once its pool is created
+func (r *Registry) lookup(name string) (*registryEntry, error) {
+	r.mu.Lock()
+	entry, ok := r.pools[name]
+	r.mu.Unlock()
+	if !ok || entry.wait() != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotRegistered, name)
+	}
+	return entry, nil
+}
+
+// Get returns the pool registered under name
+func (r *Registry) Get(name string) (*LdapConnPool, error) {
+	entry, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.pool, nil
+}
+
+// Open gets a connection from the pool registered under name
+func (r *Registry) Open(name string) (*LdapConn, error) {
+	pool, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return pool.GetConnection(context.Background())
+}
+
+// Unregister removes name, closing its pool once no other name shares it
+func (r *Registry) Unregister(name string) error {
+	entry, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.pools[name] != entry {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrPoolNotRegistered, name)
+	}
+	delete(r.pools, name)
+	entry.refs--
+	refs := entry.refs
+	r.mu.Unlock()
+
+	if refs > 0 {
+		return nil
+	}
+	return entry.pool.Close()
+}
+
+// CloseAll closes every registered pool and empties the registry
+func (r *Registry) CloseAll() error {
+	r.mu.Lock()
+	pools := r.pools
+	r.pools = make(map[string]*registryEntry)
+	r.mu.Unlock()
+
+	closed := make(map[*LdapConnPool]bool)
+	var errs []error
+	for _, entry := range pools {
+		if entry.wait() != nil || closed[entry.pool] {
+			continue
+		}
+		closed[entry.pool] = true
+		if err := entry.pool.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Register creates a named pool in the package registry
+func Register(name string, config LdapConfig) error {
+	return defaultRegistry.Register(name, config)
+}
+
+// Get returns the named pool from the package registry
+func Get(name string) (*LdapConnPool, error) {
+	return defaultRegistry.Get(name)
+}
+
+// OpenNamed gets a connection from the named pool in the package registry
+func OpenNamed(name string) (*LdapConn, error) {
+	return defaultRegistry.Open(name)
+}
+
+// Unregister removes a named pool from the package registry
+func Unregister(name string) error {
+	return defaultRegistry.Unregister(name)
+}
+
+// CloseAll closes every pool in the package registry
+func CloseAll() error {
+	return defaultRegistry.CloseAll()
+}
@@ -0,0 +1,187 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("Named pools", func(t *testing.T) {
+		corp := newFakeServer(t)
+		customer := newFakeServer(t)
+		r := NewRegistry()
+		defer r.CloseAll()
+
+		if err := r.Register("corp", corp.config()); err != nil {
+			t.Fatalf("Failed to register corp: %v", err)
+		}
+		if err := r.Register("customer", customer.config()); err != nil {
+			t.Fatalf("Failed to register customer: %v", err)
+		}
+
+		conn, err := r.Open("customer")
+		if err != nil {
+			t.Fatalf("Failed to open customer connection: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != customer.url {
+			t.Errorf("Expected connection to %s, got %s", customer.url, conn.Server())
+		}
+
+		if _, err := r.Get("missing"); !errors.Is(err, ErrPoolNotRegistered) {
+			t.Errorf("Expected ErrPoolNotRegistered, got %v", err)
+		}
+		if err := r.Register("corp", customer.config()); !errors.Is(err, ErrPoolRegistered) {
+			t.Errorf("Expected ErrPoolRegistered, got %v", err)
+		}
+		if err := r.Register("corp", corp.config()); err != nil {
+			t.Errorf("Expected re-registering the same config to be a no-op, got %v", err)
+		}
+	})
+
+	t.Run("Identical configs share a pool", func(t *testing.T) {
+		server := newFakeServer(t)
+		r := NewRegistry()
+		defer r.CloseAll()
+
+		config := server.config()
+		if err := r.Register("a", config); err != nil {
+			t.Fatalf("Failed to register a: %v", err)
+		}
+		// Explicit defaults are the same configuration
+		config.ServerBackoff = 30 * time.Second
+		if err := r.Register("b", config); err != nil {
+			t.Fatalf("Failed to register b: %v", err)
+		}
+
+		a, _ := r.Get("a")
+		b, _ := r.Get("b")
+		if a != b {
+			t.Fatal("Expected identical configs to share a pool")
+		}
+
+		if err := r.Unregister("a"); err != nil {
+			t.Fatalf("Failed to unregister a: %v", err)
+		}
+		if _, err := b.GetConnection(context.Background()); err != nil {
+			t.Errorf("Expected shared pool to stay open, got %v", err)
+		}
+		if err := r.Unregister("b"); err != nil {
+			t.Fatalf("Failed to unregister b: %v", err)
+		}
+		if _, err := b.GetConnection(context.Background()); err != ErrPoolClosed {
+			t.Errorf("Expected pool to close with its last name, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent unregisters close a shared pool once", func(t *testing.T) {
+		server := newFakeServer(t)
+		r := NewRegistry()
+		defer r.CloseAll()
+
+		names := []string{"a", "b", "c", "d"}
+		for _, name := range names {
+			if err := r.Register(name, server.config()); err != nil {
+				t.Fatalf("Failed to register %s: %v", name, err)
+			}
+		}
+		pool, _ := r.Get("a")
+
+		errs := make(chan error, len(names))
+		for _, name := range names {
+			go func() { errs <- r.Unregister(name) }()
+		}
+		for range names {
+			if err := <-errs; err != nil {
+				t.Errorf("Unregister failed: %v", err)
+			}
+		}
+		if _, err := pool.GetConnection(context.Background()); err != ErrPoolClosed {
+			t.Errorf("Expected pool to close with its last name, got %v", err)
+		}
+	})
+
+	t.Run("Slow registration does not block other names", func(t *testing.T) {
+		slow := newFakeServer(t)
+		slow.bindDelay = 500 * time.Millisecond
+		fast := newFakeServer(t)
+		r := NewRegistry()
+		defer r.CloseAll()
+
+		registered := make(chan error, 1)
+		go func() { registered <- r.Register("slow", slow.config()) }()
+		for slow.Accepted() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		start := time.Now()
+		if err := r.Register("fast", fast.config()); err != nil {
+			t.Fatalf("Failed to register fast: %v", err)
+		}
+		conn, err := r.Open("fast")
+		if err != nil {
+			t.Fatalf("Failed to open fast connection: %v", err)
+		}
+		conn.Close()
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Errorf("Expected other names to stay usable while a pool dials, took %v", elapsed)
+		}
+
+		// The name being registered resolves once its pool is ready
+		if _, err := r.Get("slow"); err != nil {
+			t.Errorf("Expected slow pool once registered, got %v", err)
+		}
+		if err := <-registered; err != nil {
+			t.Errorf("Failed to register slow: %v", err)
+		}
+	})
+
+	t.Run("Failed registration releases the name", func(t *testing.T) {
+		server := newFakeServer(t)
+		server.setPassword("changed")
+		r := NewRegistry()
+		defer r.CloseAll()
+
+		config := server.config()
+		if err := r.Register("broken", config); err == nil {
+			t.Fatal("Expected registration to fail")
+		}
+		if _, err := r.Get("broken"); !errors.Is(err, ErrPoolNotRegistered) {
+			t.Errorf("Expected ErrPoolNotRegistered, got %v", err)
+		}
+		server.setPassword(config.AdminPass)
+		if err := r.Register("broken", config); err != nil {
+			t.Errorf("Expected registration to be retried, got %v", err)
+		}
+	})
+
+	t.Run("CloseAll", func(t *testing.T) {
+		server := newFakeServer(t)
+		defer CloseAll()
+
+		if err := Register("test", server.config()); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+		pool, err := Get("test")
+		if err != nil {
+			t.Fatalf("Failed to get pool: %v", err)
+		}
+		conn, err := OpenNamed("test")
+		if err != nil {
+			t.Fatalf("Failed to open connection: %v", err)
+		}
+		conn.Close()
+
+		if err := CloseAll(); err != nil {
+			t.Errorf("CloseAll failed: %v", err)
+		}
+		if _, err := pool.GetConnection(context.Background()); err != ErrPoolClosed {
+			t.Errorf("Expected pool to be closed, got %v", err)
+		}
+		if _, err := Get("test"); !errors.Is(err, ErrPoolNotRegistered) {
+			t.Errorf("Expected registry to be empty, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package ldapool
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// RetryPolicy controls how the pool level operations (Search, Add, ...)
+// retry when the borrowed connection turns out to be stale
+type RetryPolicy struct {
+	// retries after the first attempt, defaults to 2; negative disables retrying
+	MaxRetries int
+	// delay before each retry
+	Backoff time.Duration
+	// also retry Add, Modify, ModifyDN, Delete and PasswordModify. A write that
+	// failed on a dropped connection may still have been applied, so retrying
+	// can apply it twice.
+	RetryWrites bool
+}
+
+// retries returns how often an operation may be retried
+func (p RetryPolicy) retries(write bool) int {
+	if p.MaxRetries < 0 || (write && !p.RetryWrites) {
+		return 0
+	}
+	return p.MaxRetries
+}
+
+// isStale reports whether op failed because conn is no longer usable, so it
+// should be discarded and the operation retried on another connection
+func isStale(conn *LdapConn, err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.ErrorNetwork) || conn.IsClosing()
+}
+
+// do runs op on a borrowed connection. A stale connection is discarded and,
+// as allowed by the retry policy, op is retried on another one.
+func (lcp *LdapConnPool) do(ctx context.Context, write bool, op func(*LdapConn) error) error {
+	retries := lcp.config.Retry.retries(write)
+	for attempt := 0; ; attempt++ {
+		conn, err := lcp.GetConnection(ctx)
+		if err != nil {
+			return err
+		}
+		err = op(conn)
+		if err == nil || !isStale(conn, err) {
+			conn.Close()
+			return err
+		}
+		lcp.discard(conn)
+		if attempt >= retries {
+			return err
+		}
+		if backoff := lcp.config.Retry.Backoff; backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+	}
+}
+
+// Search runs a search on a pooled connection
+func (lcp *LdapConnPool) Search(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := lcp.do(ctx, false, func(conn *LdapConn) (err error) {
+		result, err = conn.SearchContext(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Compare checks whether the attribute of dn has the given value
+func (lcp *LdapConnPool) Compare(ctx context.Context, dn, attribute, value string) (bool, error) {
+	var ok bool
+	err := lcp.do(ctx, false, func(conn *LdapConn) (err error) {
+		ok, err = conn.CompareContext(ctx, dn, attribute, value)
+		return err
+	})
+	return ok, err
+}
+
+// Add adds an entry
+func (lcp *LdapConnPool) Add(ctx context.Context, req *ldap.AddRequest) error {
+	return lcp.do(ctx, true, func(conn *LdapConn) error {
+		return conn.AddContext(ctx, req)
+	})
+}
+
+// Modify modifies an entry
+func (lcp *LdapConnPool) Modify(ctx context.Context, req *ldap.ModifyRequest) error {
+	return lcp.do(ctx, true, func(conn *LdapConn) error {
+		return conn.ModifyContext(ctx, req)
+	})
+}
+
+// ModifyDN renames or moves an entry
+func (lcp *LdapConnPool) ModifyDN(ctx context.Context, req *ldap.ModifyDNRequest) error {
+	return lcp.do(ctx, true, func(conn *LdapConn) error {
+		return conn.ModifyDNContext(ctx, req)
+	})
+}
+
+// Delete deletes an entry
+func (lcp *LdapConnPool) Delete(ctx context.Context, req *ldap.DelRequest) error {
+	return lcp.do(ctx, true, func(conn *LdapConn) error {
+		return conn.DelContext(ctx, req)
+	})
+}
+
+// PasswordModify changes a user's password
+func (lcp *LdapConnPool) PasswordModify(ctx context.Context, req *ldap.PasswordModifyRequest) (*ldap.PasswordModifyResult, error) {
+	var result *ldap.PasswordModifyResult
+	err := lcp.do(ctx, true, func(conn *LdapConn) (err error) {
+		result, err = conn.PasswordModifyContext(ctx, req)
+		return err
+	})
+	return result, err
+}
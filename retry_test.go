@@ -0,0 +1,127 @@
+package ldapool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestPoolOperations(t *testing.T) {
+	server := newFakeServer(t)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	result, err := pool.Search(ctx, ldap.NewSearchRequest(config.BaseDN, ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	if err != nil || len(result.Entries) != 1 || result.Entries[0].DN != config.BaseDN {
+		t.Fatalf("Unexpected search result %v, %v", result, err)
+	}
+	if ok, err := pool.Compare(ctx, config.BaseDN, "objectClass", "top"); err != nil || !ok {
+		t.Errorf("Expected compare to succeed, got %v, %v", ok, err)
+	}
+	if err := pool.Add(ctx, ldap.NewAddRequest("cn=a,"+config.BaseDN, nil)); err != nil {
+		t.Errorf("Add failed: %v", err)
+	}
+	if err := pool.Modify(ctx, ldap.NewModifyRequest("cn=a,"+config.BaseDN, nil)); err != nil {
+		t.Errorf("Modify failed: %v", err)
+	}
+	if err := pool.ModifyDN(ctx, ldap.NewModifyDNRequest("cn=a,"+config.BaseDN, "cn=b", true, "")); err != nil {
+		t.Errorf("ModifyDN failed: %v", err)
+	}
+	if err := pool.Delete(ctx, ldap.NewDelRequest("cn=b,"+config.BaseDN, nil)); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if open, idle := pool.Stats(); open != 1 || idle != 1 {
+		t.Errorf("Expected the connection to be returned after each operation, got %d open and %d idle", open, idle)
+	}
+}
+
+func TestPoolOperationRetry(t *testing.T) {
+	search := ldap.NewSearchRequest("dc=eryajf,dc=net", ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil)
+	del := ldap.NewDelRequest("cn=a,dc=eryajf,dc=net", nil)
+
+	newPool := func(t *testing.T, server *fakeServer, retry RetryPolicy) *LdapConnPool {
+		config := server.config()
+		config.LazyConnect = true
+		config.Retry = retry
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		t.Cleanup(func() { pool.Close() })
+		return pool
+	}
+
+	t.Run("Reads are retried on a fresh connection", func(t *testing.T) {
+		server := newFakeServer(t)
+		pool := newPool(t, server, RetryPolicy{})
+		server.dropRequests(2)
+		if _, err := pool.Search(context.Background(), search); err != nil {
+			t.Fatalf("Expected search to be retried, got %v", err)
+		}
+		if n := server.Accepted(); n != 3 {
+			t.Errorf("Expected 3 connections, got %d", n)
+		}
+		if stats := pool.PoolStats(); stats.Open != 1 || stats.BrokenClosed != 2 {
+			t.Errorf("Expected the stale connections to be discarded, got %+v", stats)
+		}
+	})
+
+	t.Run("Retries are limited", func(t *testing.T) {
+		server := newFakeServer(t)
+		pool := newPool(t, server, RetryPolicy{MaxRetries: 1})
+		server.dropRequests(2)
+		if _, err := pool.Search(context.Background(), search); err == nil {
+			t.Fatal("Expected search to fail")
+		}
+		if n := server.Requests(ldap.ApplicationSearchRequest); n != 2 {
+			t.Errorf("Expected 2 attempts, got %d", n)
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected no open connections, got %d", open)
+		}
+	})
+
+	t.Run("Retrying can be disabled", func(t *testing.T) {
+		server := newFakeServer(t)
+		pool := newPool(t, server, RetryPolicy{MaxRetries: -1})
+		server.dropRequests(1)
+		if _, err := pool.Search(context.Background(), search); err == nil {
+			t.Fatal("Expected search to fail")
+		}
+		if n := server.Requests(ldap.ApplicationSearchRequest); n != 1 {
+			t.Errorf("Expected a single attempt, got %d", n)
+		}
+	})
+
+	t.Run("Writes are not retried by default", func(t *testing.T) {
+		server := newFakeServer(t)
+		pool := newPool(t, server, RetryPolicy{})
+		server.dropRequests(1)
+		if err := pool.Delete(context.Background(), del); err == nil {
+			t.Fatal("Expected delete to fail")
+		}
+		if n := server.Requests(ldap.ApplicationDelRequest); n != 1 {
+			t.Errorf("Expected a single attempt, got %d", n)
+		}
+	})
+
+	t.Run("Writes are retried when opted in", func(t *testing.T) {
+		server := newFakeServer(t)
+		pool := newPool(t, server, RetryPolicy{RetryWrites: true})
+		server.dropRequests(1)
+		if err := pool.Delete(context.Background(), del); err != nil {
+			t.Fatalf("Expected delete to be retried, got %v", err)
+		}
+		if n := server.Requests(ldap.ApplicationDelRequest); n != 2 {
+			t.Errorf("Expected 2 attempts, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package ldapool
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStrategy selects which server a new connection is dialed to
+type ServerStrategy int
+
+const (
+	// StrategyFailover always prefers the first healthy server in the list
+	StrategyFailover ServerStrategy = iota
+	// StrategyRoundRobin rotates through the healthy servers
+	StrategyRoundRobin
+	// StrategyRandom picks a random healthy server
+	StrategyRandom
+)
+
+// serverState tracks the health of a single server
+type serverState struct {
+	url string
+	// rank orders servers by preference; servers sharing a rank, such as SRV
+	// targets of the same priority, are equally preferred
+	rank int
+	// down is set by a failed dial and cleared by the next successful one
+	down bool
+	// retryAt is when a down server is tried again
+	retryAt time.Time
+}
+
+// serverList is the set of servers a pool dials, with per-server backoff
+type serverList struct {
+	mu       sync.Mutex
+	servers  []*serverState
+	strategy ServerStrategy
+	backoff  time.Duration
+	next     uint32
+}
+
+// newServerList creates a server list for urls
+func newServerList(urls []string, strategy ServerStrategy, backoff time.Duration) *serverList {
+	sl := &serverList{strategy: strategy, backoff: backoff}
+	sl.set(urls)
+	return sl
+}
+
+// set replaces the server list, keeping the health state of known servers.
+// Each server is preferred over the ones after it.
+func (sl *serverList) set(urls []string) {
+	groups := make([][]string, len(urls))
+	for i, url := range urls {
+		groups[i] = []string{url}
+	}
+	sl.setGroups(groups)
+}
+
+// setGroups replaces the server list with groups of equally preferred
+// servers, most preferred first. Known servers keep their health state and,
+// within their group, their current order ahead of new servers.
+func (sl *serverList) setGroups(groups [][]string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	known := make(map[string]int, len(sl.servers))
+	for i, s := range sl.servers {
+		known[s.url] = i
+	}
+	servers := make([]*serverState, 0, len(sl.servers))
+	for rank, group := range groups {
+		start := len(servers)
+		for _, url := range group {
+			s := &serverState{url: url}
+			if i, ok := known[url]; ok {
+				s = sl.servers[i]
+			}
+			s.rank = rank
+			servers = append(servers, s)
+		}
+		added := servers[start:]
+		sort.SliceStable(added, func(a, b int) bool {
+			i, aKnown := known[added[a].url]
+			j, bKnown := known[added[b].url]
+			return aKnown && (!bKnown || i < j)
+		})
+	}
+	sl.servers = servers
+}
+
+// candidates returns the servers to try for the next dial, in order. Servers
+// backing off are left out unless every server is backing off, in which case
+// all of them are tried rather than failing without a single attempt.
+func (sl *serverList) candidates() []string {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := time.Now()
+	urls := make([]string, 0, len(sl.servers))
+	for _, s := range sl.servers {
+		if !s.down || !now.Before(s.retryAt) {
+			urls = append(urls, s.url)
+		}
+	}
+	if len(urls) == 0 {
+		for _, s := range sl.servers {
+			urls = append(urls, s.url)
+		}
+	}
+
+	switch sl.strategy {
+	case StrategyRoundRobin:
+		if n := len(urls); n > 1 {
+			start := int((atomic.AddUint32(&sl.next, 1) - 1) % uint32(n))
+			rotated := make([]string, 0, n)
+			rotated = append(rotated, urls[start:]...)
+			urls = append(rotated, urls[:start]...)
+		}
+	case StrategyRandom:
+		rand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+	}
+	return urls
+}
+
+// markDown takes url out of rotation for the backoff period
+func (sl *serverList) markDown(url string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if s := sl.lookupLocked(url); s != nil {
+		s.down = true
+		s.retryAt = time.Now().Add(sl.backoff)
+	}
+}
+
+// markUp puts url back into rotation after a successful dial
+func (sl *serverList) markUp(url string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if s := sl.lookupLocked(url); s != nil {
+		s.down = false
+		s.retryAt = time.Time{}
+	}
+}
+
+// retired reports whether connections to url should be drained: either the
+// server was removed from the list, or with StrategyFailover a server
+// preferred over it is healthy again
+func (sl *serverList) retired(url string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	s := sl.lookupLocked(url)
+	if s == nil {
+		return true
+	}
+	if sl.strategy != StrategyFailover {
+		return false
+	}
+	for _, other := range sl.servers {
+		if other.rank < s.rank && !other.down {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupLocked returns the state for url. Must be called with sl.mu held.
+func (sl *serverList) lookupLocked(url string) *serverState {
+	for _, s := range sl.servers {
+		if s.url == url {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package ldapool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServerList(t *testing.T) {
+	urls := []string{"ldap://a", "ldap://b", "ldap://c"}
+
+	t.Run("Failover prefers the first healthy server", func(t *testing.T) {
+		sl := newServerList(urls, StrategyFailover, time.Minute)
+		if got := sl.candidates(); got[0] != "ldap://a" || len(got) != 3 {
+			t.Errorf("Unexpected candidates %v", got)
+		}
+
+		sl.markDown("ldap://a")
+		if got := sl.candidates(); got[0] != "ldap://b" || len(got) != 2 {
+			t.Errorf("Expected down server to be skipped, got %v", got)
+		}
+		if sl.retired("ldap://b") {
+			t.Error("Expected b to stay in use while a is down")
+		}
+		if !sl.retired("ldap://c") {
+			t.Error("Expected c to be drained while b is healthy")
+		}
+
+		sl.markUp("ldap://a")
+		if !sl.retired("ldap://b") {
+			t.Error("Expected b to be drained once a recovered")
+		}
+	})
+
+	t.Run("Round robin rotates", func(t *testing.T) {
+		sl := newServerList(urls, StrategyRoundRobin, time.Minute)
+		seen := make(map[string]int)
+		for i := 0; i < 6; i++ {
+			seen[sl.candidates()[0]]++
+		}
+		for _, url := range urls {
+			if seen[url] != 2 {
+				t.Errorf("Expected %s to be first twice, got %d", url, seen[url])
+			}
+		}
+		if sl.retired("ldap://c") {
+			t.Error("Round robin should not drain healthy servers")
+		}
+	})
+
+	t.Run("Random keeps every healthy server", func(t *testing.T) {
+		sl := newServerList(urls, StrategyRandom, time.Minute)
+		sl.markDown("ldap://b")
+		got := sl.candidates()
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 candidates, got %v", got)
+		}
+		for _, url := range got {
+			if url == "ldap://b" {
+				t.Error("Expected down server to be skipped")
+			}
+		}
+	})
+
+	t.Run("Backoff expires", func(t *testing.T) {
+		sl := newServerList(urls, StrategyFailover, time.Millisecond)
+		sl.markDown("ldap://a")
+		time.Sleep(5 * time.Millisecond)
+		if got := sl.candidates(); got[0] != "ldap://a" {
+			t.Errorf("Expected a to be retried after backoff, got %v", got)
+		}
+	})
+
+	t.Run("All servers down are still tried", func(t *testing.T) {
+		sl := newServerList(urls, StrategyFailover, time.Minute)
+		for _, url := range urls {
+			sl.markDown(url)
+		}
+		if got := sl.candidates(); len(got) != 3 {
+			t.Errorf("Expected every server to be tried, got %v", got)
+		}
+	})
+
+	t.Run("Removed servers are retired", func(t *testing.T) {
+		sl := newServerList(urls, StrategyRoundRobin, time.Minute)
+		sl.markDown("ldap://c")
+		sl.set([]string{"ldap://c", "ldap://d"})
+		if !sl.retired("ldap://a") {
+			t.Error("Expected removed server to be retired")
+		}
+		if got := sl.candidates(); len(got) != 1 || got[0] != "ldap://d" {
+			t.Errorf("Expected health state of c to be kept, got %v", got)
+		}
+	})
+
+	t.Run("Groups keep the order of known servers", func(t *testing.T) {
+		sl := newServerList(nil, StrategyFailover, time.Minute)
+		sl.setGroups([][]string{{"ldap://a", "ldap://b"}, {"ldap://c"}})
+		sl.setGroups([][]string{{"ldap://d", "ldap://b", "ldap://a"}, {"ldap://c"}})
+		want := []string{"ldap://a", "ldap://b", "ldap://d", "ldap://c"}
+		got := sl.candidates()
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("Expected %v, got %v", want, got)
+			}
+		}
+		if sl.retired("ldap://b") || sl.retired("ldap://d") {
+			t.Error("Expected equally preferred servers to stay in use")
+		}
+		if !sl.retired("ldap://c") {
+			t.Error("Expected c to be drained while a higher priority server is healthy")
+		}
+	})
+}
+
+func TestServerFailover(t *testing.T) {
+	t.Run("Unreachable server is skipped", func(t *testing.T) {
+		down := newFakeServer(t)
+		down.Close()
+		up := newFakeServer(t)
+
+		config := up.config()
+		config.Url = ""
+		config.Urls = []string{down.url, up.url}
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		conn, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != up.url {
+			t.Errorf("Expected connection to %s, got %s", up.url, conn.Server())
+		}
+		if got := pool.servers.candidates(); len(got) != 1 || got[0] != up.url {
+			t.Errorf("Expected unreachable server to back off, got %v", got)
+		}
+	})
+
+	t.Run("Connections drain when the preferred server recovers", func(t *testing.T) {
+		primary := newFakeServer(t)
+		secondary := newFakeServer(t)
+
+		config := primary.config()
+		config.Urls = []string{primary.url, secondary.url}
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		pool.servers.markDown(primary.url)
+		fallback, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		if fallback.Server() != secondary.url {
+			t.Fatalf("Expected connection to %s, got %s", secondary.url, fallback.Server())
+		}
+
+		// Let the primary's backoff expire so the next dial recovers it
+		pool.servers.mu.Lock()
+		pool.servers.servers[0].retryAt = time.Now()
+		pool.servers.mu.Unlock()
+
+		conn, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		if conn.Server() != primary.url {
+			t.Fatalf("Expected connection to %s, got %s", primary.url, conn.Server())
+		}
+
+		fallback.Close()
+		if open, idle := pool.Stats(); open != 1 || idle != 0 {
+			t.Errorf("Expected the fallback connection to be drained, got %d open, %d idle", open, idle)
+		}
+	})
+
+	t.Run("Removed server is drained", func(t *testing.T) {
+		a := newFakeServer(t)
+		b := newFakeServer(t)
+
+		config := a.config()
+		config.Urls = []string{a.url, b.url}
+		config.ServerStrategy = StrategyRoundRobin
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		conns := make([]*LdapConn, 4)
+		for i := range conns {
+			if conns[i], err = pool.GetConnection(context.Background()); err != nil {
+				t.Fatalf("Failed to get connection: %v", err)
+			}
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		if err := pool.SetServers([]string{a.url}); err != nil {
+			t.Fatalf("Failed to set servers: %v", err)
+		}
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		if len(pool.conns) != 2 {
+			t.Errorf("Expected 2 idle connections left, got %d", len(pool.conns))
+		}
+		for _, conn := range pool.conns {
+			if conn.Server() != a.url {
+				t.Errorf("Expected connections to %s only, got %s", a.url, conn.Server())
+			}
+		}
+	})
+}
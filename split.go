@@ -0,0 +1,167 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// SplitConfig configures a SplitPool
+type SplitConfig struct {
+	// the writable provider
+	Primary LdapConfig
+	// the read-only consumers, usually listed in Urls. When no server is
+	// configured, reads go to the primary.
+	Replica LdapConfig
+	// how long reads in a WithSession context stay on the primary after a write
+	ReadYourWritesWindow time.Duration
+}
+
+// SplitPool routes reads (Search, Compare) to replicas and writes (Add,
+// Modify, Del, ModifyDN) to the primary
+type SplitPool struct {
+	primary *LdapConnPool
+	replica *LdapConnPool
+	window  time.Duration
+}
+
+// sessionKey is the context key for the write tracking of WithSession
+type sessionKey struct{}
+
+// session records the time of the last write made through a context
+type session struct {
+	lastWrite int64
+}
+
+// WithSession returns a context that remembers writes made through a
+// SplitPool, so later reads in the same context can see them
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+// NewSplitPool creates the primary and replica pools
+func NewSplitPool(config SplitConfig) (*SplitPool, error) {
+	primary, err := NewPool(config.Primary)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &SplitPool{primary: primary, replica: primary, window: config.ReadYourWritesWindow}
+	replica := config.Replica
+	if replica.Url != "" || len(replica.Urls) > 0 || replica.SRVDomain != "" {
+		if sp.replica, err = NewPool(replica); err != nil {
+			primary.Close()
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+// Primary returns the pool of the writable provider
+func (sp *SplitPool) Primary() *LdapConnPool {
+	return sp.primary
+}
+
+// Replica returns the pool of the read-only consumers
+func (sp *SplitPool) Replica() *LdapConnPool {
+	return sp.replica
+}
+
+// ReadConnection gets a connection for reading. Within ReadYourWritesWindow
+// of a write in the same WithSession context this is a primary connection.
+func (sp *SplitPool) ReadConnection(ctx context.Context) (*LdapConn, error) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok && sp.window > 0 {
+		if last := atomic.LoadInt64(&s.lastWrite); last != 0 && time.Since(time.Unix(0, last)) < sp.window {
+			return sp.primary.GetConnection(ctx)
+		}
+	}
+	return sp.replica.GetConnection(ctx)
+}
+
+// WriteConnection gets a primary connection. Returning it records a write in
+// the WithSession context, if any, so ReadYourWritesWindow starts once the
+// writes through it have finished.
+func (sp *SplitPool) WriteConnection(ctx context.Context) (*LdapConn, error) {
+	conn, err := sp.primary.GetConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		conn.onReturn = func() {
+			atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+		}
+	}
+	return conn, nil
+}
+
+// Search runs a search against a replica
+func (sp *SplitPool) Search(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	conn, err := sp.ReadConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Search(req)
+}
+
+// Compare runs a compare against a replica
+func (sp *SplitPool) Compare(ctx context.Context, dn, attribute, value string) (bool, error) {
+	conn, err := sp.ReadConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return conn.Compare(dn, attribute, value)
+}
+
+// Add adds an entry on the primary
+func (sp *SplitPool) Add(ctx context.Context, req *ldap.AddRequest) error {
+	conn, err := sp.WriteConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Add(req)
+}
+
+// Modify modifies an entry on the primary
+func (sp *SplitPool) Modify(ctx context.Context, req *ldap.ModifyRequest) error {
+	conn, err := sp.WriteConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Modify(req)
+}
+
+// Del deletes an entry on the primary
+func (sp *SplitPool) Del(ctx context.Context, req *ldap.DelRequest) error {
+	conn, err := sp.WriteConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Del(req)
+}
+
+// ModifyDN renames or moves an entry on the primary
+func (sp *SplitPool) ModifyDN(ctx context.Context, req *ldap.ModifyDNRequest) error {
+	conn, err := sp.WriteConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.ModifyDN(req)
+}
+
+// Close closes the primary and replica pools
+func (sp *SplitPool) Close() error {
+	err := sp.primary.Close()
+	if sp.replica != sp.primary {
+		err = errors.Join(err, sp.replica.Close())
+	}
+	return err
+}
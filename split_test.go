@@ -0,0 +1,134 @@
+package ldapool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// newTestSplitPool creates a split pool over a primary and a replica fake server
+func newTestSplitPool(t *testing.T, window time.Duration) (*SplitPool, *fakeServer, *fakeServer) {
+	t.Helper()
+	primary := newFakeServer(t)
+	replica := newFakeServer(t)
+
+	sp, err := NewSplitPool(SplitConfig{
+		Primary:              primary.config(),
+		Replica:              replica.config(),
+		ReadYourWritesWindow: window,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create split pool: %v", err)
+	}
+	t.Cleanup(func() { sp.Close() })
+	return sp, primary, replica
+}
+
+func TestSplitPool(t *testing.T) {
+	search := ldap.NewSearchRequest("dc=eryajf,dc=net", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+
+	t.Run("Reads go to the replica, writes to the primary", func(t *testing.T) {
+		sp, primary, replica := newTestSplitPool(t, 0)
+		ctx := context.Background()
+
+		if _, err := sp.Search(ctx, search); err != nil {
+			t.Errorf("Search failed: %v", err)
+		}
+		if ok, err := sp.Compare(ctx, "cn=admin,dc=eryajf,dc=net", "cn", "admin"); err != nil || !ok {
+			t.Errorf("Compare failed: %v, %v", ok, err)
+		}
+		if err := sp.Add(ctx, ldap.NewAddRequest("cn=test,dc=eryajf,dc=net", nil)); err != nil {
+			t.Errorf("Add failed: %v", err)
+		}
+		if err := sp.Modify(ctx, ldap.NewModifyRequest("cn=test,dc=eryajf,dc=net", nil)); err != nil {
+			t.Errorf("Modify failed: %v", err)
+		}
+		if err := sp.ModifyDN(ctx, ldap.NewModifyDNRequest("cn=test,dc=eryajf,dc=net", "cn=moved", true, "")); err != nil {
+			t.Errorf("ModifyDN failed: %v", err)
+		}
+		if err := sp.Del(ctx, ldap.NewDelRequest("cn=moved,dc=eryajf,dc=net", nil)); err != nil {
+			t.Errorf("Del failed: %v", err)
+		}
+
+		for _, tag := range []ber.Tag{ldap.ApplicationSearchRequest, ldap.ApplicationCompareRequest} {
+			if replica.Requests(tag) != 1 || primary.Requests(tag) != 0 {
+				t.Errorf("Expected read %d on the replica only", tag)
+			}
+		}
+		for _, tag := range []ber.Tag{ldap.ApplicationAddRequest, ldap.ApplicationModifyRequest,
+			ldap.ApplicationModifyDNRequest, ldap.ApplicationDelRequest} {
+			if primary.Requests(tag) != 1 || replica.Requests(tag) != 0 {
+				t.Errorf("Expected write %d on the primary only", tag)
+			}
+		}
+	})
+
+	t.Run("Reads stick to the primary after a write", func(t *testing.T) {
+		sp, primary, replica := newTestSplitPool(t, 50*time.Millisecond)
+		ctx := WithSession(context.Background())
+
+		if err := sp.Add(ctx, ldap.NewAddRequest("cn=test,dc=eryajf,dc=net", nil)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if _, err := sp.Search(ctx, search); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if _, err := sp.Search(context.Background(), search); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if primary.Requests(ldap.ApplicationSearchRequest) != 1 || replica.Requests(ldap.ApplicationSearchRequest) != 1 {
+			t.Error("Expected only the session read to go to the primary")
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if _, err := sp.Search(ctx, search); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if replica.Requests(ldap.ApplicationSearchRequest) != 2 {
+			t.Error("Expected reads to return to the replica after the window")
+		}
+	})
+
+	t.Run("The window starts when the write connection is returned", func(t *testing.T) {
+		sp, primary, _ := newTestSplitPool(t, 50*time.Millisecond)
+		ctx := WithSession(context.Background())
+
+		conn, err := sp.WriteConnection(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get write connection: %v", err)
+		}
+		// A write taking longer than the window
+		time.Sleep(60 * time.Millisecond)
+		if err := conn.Add(ldap.NewAddRequest("cn=test,dc=eryajf,dc=net", nil)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		conn.Close()
+
+		if _, err := sp.Search(ctx, search); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if primary.Requests(ldap.ApplicationSearchRequest) != 1 {
+			t.Error("Expected the read after the write to go to the primary")
+		}
+	})
+
+	t.Run("Without replicas reads use the primary", func(t *testing.T) {
+		primary := newFakeServer(t)
+		sp, err := NewSplitPool(SplitConfig{Primary: primary.config()})
+		if err != nil {
+			t.Fatalf("Failed to create split pool: %v", err)
+		}
+		defer sp.Close()
+
+		if sp.Replica() != sp.Primary() {
+			t.Error("Expected the primary pool to serve reads")
+		}
+		if _, err := sp.Search(context.Background(), search); err != nil {
+			t.Errorf("Search failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package ldapool
+
+import (
+	"context"
+	"iter"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// SearchAsync streams the results of req: every entry, referral and the
+// controls returned with the search result are yielded as they arrive,
+// instead of being buffered like Search does. Up to bufferSize results are
+// read ahead; beyond that the search waits for the loop to catch up. A search
+// error ends the iteration after being yielded with a nil result.
+//
+// The connection is held for the duration of the loop. When ctx is done or
+// the loop stops early the search is abandoned, and the connection is
+// validated before the pool hands it out again.
+func (lcp *LdapConnPool) SearchAsync(ctx context.Context, req *ldap.SearchRequest, bufferSize int) iter.Seq2[*ldap.SearchSingleResult, error] {
+	return func(yield func(*ldap.SearchSingleResult, error) bool) {
+		conn, err := lcp.GetConnection(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		stopped := false
+		err = conn.stream(ctx, req, bufferSize, func(res *ldap.SearchSingleResult) bool {
+			stopped = !yield(res, nil)
+			return !stopped
+		})
+		if err != nil && isStale(conn, err) {
+			lcp.discard(conn)
+		} else {
+			conn.Close()
+		}
+		if err != nil && !stopped {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestSearchAsync(t *testing.T) {
+	server := newFakeServer(t)
+	server.setEntries(500)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	entries := 0
+	for res, err := range pool.SearchAsync(context.Background(), req, 16) {
+		if err != nil {
+			t.Fatalf("Streaming search failed: %v", err)
+		}
+		if res.Entry != nil {
+			entries++
+		}
+	}
+	if entries != 500 {
+		t.Errorf("Expected 500 entries, got %d", entries)
+	}
+	if stats := pool.PoolStats(); stats.InUse != 0 || stats.Abandoned != 0 {
+		t.Errorf("Expected the connection back without abandoning, got %d in use and %d abandoned", stats.InUse, stats.Abandoned)
+	}
+}
+
+func TestSearchAsyncStopEarly(t *testing.T) {
+	server := newFakeServer(t)
+	server.setEntries(2000)
+	config := server.config()
+	config.MaxOpen = 1
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	seen := 0
+	for _, err := range pool.SearchAsync(context.Background(), req, 0) {
+		if err != nil {
+			t.Fatalf("Streaming search failed: %v", err)
+		}
+		if seen++; seen == 10 {
+			break
+		}
+	}
+	waitRequests(t, server, ldap.ApplicationAbandonRequest, 1)
+	accepted := server.Accepted()
+
+	// The connection is validated and usable for the next search
+	server.setEntries(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := pool.Search(ctx, rootDSERequest); err != nil {
+		t.Fatalf("Search after stopping early failed: %v", err)
+	}
+	if stats := pool.PoolStats(); stats.BrokenClosed != 0 || server.Accepted() != accepted {
+		t.Errorf("Expected the connection to be reused, got %d broken and %d new connections",
+			stats.BrokenClosed, server.Accepted()-accepted)
+	}
+}
+
+func TestSearchAsyncCancel(t *testing.T) {
+	server := newFakeServer(t)
+	server.setEntries(2000)
+	config := server.config()
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	var last error
+	seen := 0
+	for _, err := range pool.SearchAsync(ctx, req, 64) {
+		if err != nil {
+			last = err
+			continue
+		}
+		if seen++; seen == 10 {
+			cancel()
+		}
+	}
+	if !errors.Is(last, context.Canceled) {
+		t.Errorf("Expected the stream to end with context.Canceled, got %v", last)
+	}
+	if seen != 10 {
+		t.Errorf("Expected no results after the cancel, got %d", seen-10)
+	}
+}
@@ -0,0 +1,112 @@
+package ldapool
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName identifies ldapool as the source of spans and metrics
+const instrumentationName = "github.com/eryajf/ldapool"
+
+// Span attribute keys
+const (
+	attrPool       = attribute.Key("ldapool.name")
+	attrServer     = attribute.Key("server.address")
+	attrWaited     = attribute.Key("ldapool.waited")
+	attrDN         = attribute.Key("ldap.dn")
+	attrBaseDN     = attribute.Key("ldap.base_dn")
+	attrScope      = attribute.Key("ldap.scope")
+	attrResultCode = attribute.Key("ldap.result_code")
+)
+
+// newTracer returns the tracer for config, a no-op one when tracing is off
+func newTracer(config LdapConfig) trace.Tracer {
+	if config.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(instrumentationName)
+	}
+	return config.TracerProvider.Tracer(instrumentationName)
+}
+
+// startSpan starts a span for pool activity, tagged with the pool name
+func (lcp *LdapConnPool) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if lcp.config.Name != "" {
+		attrs = append(attrs, attrPool.String(lcp.config.Name))
+	}
+	return lcp.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endOpSpan ends the span of an LDAP operation, recording its result code
+func endOpSpan(span trace.Span, err error) {
+	code := int64(ldap.LDAPResultSuccess)
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		code = int64(ldapErr.ResultCode)
+	}
+	span.SetAttributes(attrResultCode.Int64(code))
+	endSpan(span, err)
+}
+
+// registerMetrics exposes the pool gauges through config.MeterProvider
+func (lcp *LdapConnPool) registerMetrics() error {
+	if lcp.config.MeterProvider == nil {
+		return nil
+	}
+	meter := lcp.config.MeterProvider.Meter(instrumentationName)
+
+	maxOpen, err := meter.Int64ObservableGauge("ldapool.connections.max",
+		metric.WithDescription("Maximum number of open connections."), metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	open, err := meter.Int64ObservableGauge("ldapool.connections.open",
+		metric.WithDescription("Number of established connections, both in use and idle."), metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	inUse, err := meter.Int64ObservableGauge("ldapool.connections.in_use",
+		metric.WithDescription("Number of connections currently borrowed or being dialed."), metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	idle, err := meter.Int64ObservableGauge("ldapool.connections.idle",
+		metric.WithDescription("Number of idle connections."), metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	waiters, err := meter.Int64ObservableGauge("ldapool.waiters",
+		metric.WithDescription("Number of requests waiting for a connection."), metric.WithUnit("{request}"))
+	if err != nil {
+		return err
+	}
+
+	var opts []metric.ObserveOption
+	if lcp.config.Name != "" {
+		opts = append(opts, metric.WithAttributes(attrPool.String(lcp.config.Name)))
+	}
+	lcp.metrics, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		stats := lcp.PoolStats()
+		o.ObserveInt64(maxOpen, int64(stats.MaxOpen), opts...)
+		o.ObserveInt64(open, int64(stats.Open), opts...)
+		o.ObserveInt64(inUse, int64(stats.InUse), opts...)
+		o.ObserveInt64(idle, int64(stats.Idle), opts...)
+		o.ObserveInt64(waiters, int64(stats.Waiters), opts...)
+		return nil
+	}, maxOpen, open, inUse, idle, waiters)
+	return err
+}
@@ -0,0 +1,154 @@
+package ldapool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttr returns the value of key on span, if set
+func spanAttr(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing(t *testing.T) {
+	server := newFakeServer(t)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	config := server.config()
+	config.Name = "users"
+	config.LazyConnect = true
+	config.TracerProvider = provider
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	conn, err := pool.GetConnection(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	req := ldap.NewSearchRequest(config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", nil, nil)
+	if _, err := conn.Search(req); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	conn.Close()
+	parent.End()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	for _, name := range []string{"ldapool.acquire", "ldapool.dial", "ldapool.bind", "ldap.search"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("Missing span %s, got %v", name, exporter.GetSpans())
+		}
+	}
+
+	acquire := spans["ldapool.acquire"]
+	if acquire.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected acquire span to be a child of the caller's span")
+	}
+	for _, name := range []string{"ldapool.dial", "ldapool.bind"} {
+		if spans[name].Parent.SpanID() != acquire.SpanContext.SpanID() {
+			t.Errorf("Expected %s span to be a child of the acquire span", name)
+		}
+		if v, _ := spanAttr(spans[name], attrServer); v.AsString() != server.url {
+			t.Errorf("Expected %s span for server %s, got %q", name, server.url, v.AsString())
+		}
+	}
+	if v, _ := spanAttr(acquire, attrPool); v.AsString() != "users" {
+		t.Errorf("Expected pool name users, got %q", v.AsString())
+	}
+
+	search := spans["ldap.search"]
+	if search.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected search span to be a child of the caller's span")
+	}
+	if v, _ := spanAttr(search, attrBaseDN); v.AsString() != config.BaseDN {
+		t.Errorf("Expected base DN %s, got %q", config.BaseDN, v.AsString())
+	}
+	if v, _ := spanAttr(search, attrScope); v.AsString() != "Whole Subtree" {
+		t.Errorf("Expected whole subtree scope, got %q", v.AsString())
+	}
+	if v, ok := spanAttr(search, attrResultCode); !ok || v.AsInt64() != ldap.LDAPResultSuccess {
+		t.Errorf("Expected success result code, got %v", v.AsInt64())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server := newFakeServer(t)
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+
+	config := server.config()
+	config.MaxOpen = 4
+	config.MeterProvider = provider
+	pool, err := NewPool(config)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.GetConnection(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Failed to collect metrics: %v", err)
+	}
+	got := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if gauge, ok := m.Data.(metricdata.Gauge[int64]); ok && len(gauge.DataPoints) == 1 {
+				got[m.Name] = gauge.DataPoints[0].Value
+			}
+		}
+	}
+	want := map[string]int64{
+		"ldapool.connections.max":    4,
+		"ldapool.connections.open":   1,
+		"ldapool.connections.in_use": 1,
+		"ldapool.connections.idle":   0,
+		"ldapool.waiters":            0,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("Expected %s = %d, got %d (present %v)", name, value, v, ok)
+		}
+	}
+
+	// Closing the pool stops reporting
+	pool.Close()
+	rm = metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Failed to collect metrics: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if gauge, ok := m.Data.(metricdata.Gauge[int64]); ok && len(gauge.DataPoints) > 0 {
+				t.Errorf("Expected no data for %s after Close", m.Name)
+			}
+		}
+	}
+}
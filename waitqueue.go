@@ -0,0 +1,83 @@
+package ldapool
+
+import (
+	"container/list"
+	"context"
+)
+
+// priorityKey is the context key marking a request for the priority lane
+type priorityKey struct{}
+
+// WithPriority returns a context whose GetConnection calls are served ahead of
+// ordinary waiters when the pool is saturated
+func WithPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, true)
+}
+
+// isPriority reports whether ctx was marked with WithPriority
+func isPriority(ctx context.Context) bool {
+	priority, _ := ctx.Value(priorityKey{}).(bool)
+	return priority
+}
+
+// connResult is what a waiting request receives: a connection, or the error
+// that prevented one from being opened on its behalf
+type connResult struct {
+	conn *LdapConn
+	err  error
+}
+
+// connRequest is a caller parked in GetConnection waiting for a connection
+type connRequest struct {
+	ch   chan connResult
+	lane *list.List
+	elem *list.Element
+	// ctx of the waiting caller, parenting spans of dials made on its behalf
+	ctx context.Context
+}
+
+// waitQueue keeps waiting requests in arrival order, with a priority lane
+// that is always drained before the normal one
+type waitQueue struct {
+	high   list.List
+	normal list.List
+}
+
+// push appends req to the tail of its lane
+func (q *waitQueue) push(req *connRequest, priority bool) {
+	req.lane = &q.normal
+	if priority {
+		req.lane = &q.high
+	}
+	req.elem = req.lane.PushBack(req)
+}
+
+// pop removes and returns the longest waiting request, or nil if none
+func (q *waitQueue) pop() *connRequest {
+	lane := &q.high
+	if lane.Len() == 0 {
+		lane = &q.normal
+	}
+	front := lane.Front()
+	if front == nil {
+		return nil
+	}
+	req := front.Value.(*connRequest)
+	q.remove(req)
+	return req
+}
+
+// remove takes req out of the queue, reporting whether it was still queued
+func (q *waitQueue) remove(req *connRequest) bool {
+	if req.elem == nil {
+		return false
+	}
+	req.lane.Remove(req.elem)
+	req.lane, req.elem = nil, nil
+	return true
+}
+
+// len returns the number of queued requests
+func (q *waitQueue) len() int {
+	return q.high.Len() + q.normal.Len()
+}
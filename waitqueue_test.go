@@ -0,0 +1,381 @@
+package ldapool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// waitForWaiters blocks until the pool has n queued requests
+func waitForWaiters(t *testing.T, pool *LdapConnPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		pool.mu.Lock()
+		queued := pool.waiters.len()
+		pool.mu.Unlock()
+		if queued >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d queued requests", n)
+}
+
+func TestWaitQueue(t *testing.T) {
+	t.Run("FIFO order", func(t *testing.T) {
+		var q waitQueue
+		reqs := make([]*connRequest, 5)
+		for i := range reqs {
+			reqs[i] = &connRequest{}
+			q.push(reqs[i], false)
+		}
+		for i := range reqs {
+			if got := q.pop(); got != reqs[i] {
+				t.Fatalf("Expected request %d to be served next", i)
+			}
+		}
+		if q.pop() != nil {
+			t.Error("Expected empty queue")
+		}
+	})
+
+	t.Run("Priority lane first", func(t *testing.T) {
+		var q waitQueue
+		normal := &connRequest{}
+		high := &connRequest{}
+		q.push(normal, false)
+		q.push(high, true)
+		if q.pop() != high {
+			t.Error("Expected priority request to be served first")
+		}
+		if q.pop() != normal {
+			t.Error("Expected normal request to be served second")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var q waitQueue
+		a, b := &connRequest{}, &connRequest{}
+		q.push(a, false)
+		q.push(b, false)
+		if !q.remove(a) {
+			t.Error("Expected queued request to be removed")
+		}
+		if q.remove(a) {
+			t.Error("Expected second remove to report not queued")
+		}
+		if q.len() != 1 || q.pop() != b {
+			t.Error("Expected only the remaining request to be served")
+		}
+	})
+}
+
+func TestFairWaiting(t *testing.T) {
+	server := newFakeServer(t)
+
+	t.Run("Waiters served in arrival order", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		const waiters = 10
+		var mu sync.Mutex
+		var order []int
+		var wg sync.WaitGroup
+		for i := 0; i < waiters; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				conn, err := pool.GetConnection(context.Background())
+				if err != nil {
+					t.Errorf("waiter %d: %v", id, err)
+					return
+				}
+				mu.Lock()
+				order = append(order, id)
+				mu.Unlock()
+				conn.Close()
+			}(i)
+			waitForWaiters(t, pool, i+1)
+		}
+
+		held.Close()
+		wg.Wait()
+
+		for i, id := range order {
+			if id != i {
+				t.Fatalf("Expected FIFO order, got %v", order)
+			}
+		}
+	})
+
+	t.Run("Priority waiters jump the queue", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		served := make(chan string, 2)
+		acquire := func(ctx context.Context, name string) {
+			conn, err := pool.GetConnection(ctx)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			served <- name
+			conn.Close()
+		}
+
+		go acquire(context.Background(), "normal")
+		waitForWaiters(t, pool, 1)
+		go acquire(WithPriority(context.Background()), "priority")
+		waitForWaiters(t, pool, 2)
+
+		held.Close()
+		if first := <-served; first != "priority" {
+			t.Errorf("Expected priority waiter first, got %s", first)
+		}
+		<-served
+	})
+
+	t.Run("Cancelled waiter leaves the queue", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := pool.GetConnection(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		pool.mu.Lock()
+		queued := pool.waiters.len()
+		pool.mu.Unlock()
+		if queued != 0 {
+			t.Errorf("Expected empty wait queue, got %d", queued)
+		}
+
+		held.Close()
+		if open, idle := pool.Stats(); open != 1 || idle != 1 {
+			t.Errorf("Expected 1 open and 1 idle connection, got %d open, %d idle", open, idle)
+		}
+	})
+
+	t.Run("No starvation under contention", func(t *testing.T) {
+		config := server.config()
+		config.MaxOpen = 2
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		const (
+			workers    = 50
+			iterations = 20
+		)
+		counts := make([]int, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for j := 0; j < iterations; j++ {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					conn, err := pool.GetConnection(ctx)
+					cancel()
+					if err != nil {
+						t.Errorf("worker %d starved: %v", id, err)
+						return
+					}
+					time.Sleep(100 * time.Microsecond)
+					conn.Close()
+					counts[id]++
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for id, n := range counts {
+			if n != iterations {
+				t.Errorf("worker %d completed %d of %d acquisitions", id, n, iterations)
+			}
+		}
+	})
+}
+
+func TestWaitersWokenOnDiscard(t *testing.T) {
+	t.Run("Broken connection frees capacity", func(t *testing.T) {
+		server := newFakeServer(t)
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		got := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			conn, err := pool.GetConnection(ctx)
+			if err == nil {
+				if conn.IsClosing() {
+					err = ErrConnClosed
+				}
+				conn.Close()
+			}
+			got <- err
+		}()
+		waitForWaiters(t, pool, 1)
+
+		// Break the connection before returning it
+		held.Conn.Close()
+		held.Close()
+
+		select {
+		case err := <-got:
+			if err != nil {
+				t.Errorf("Expected a fresh connection, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Waiter was not woken after the connection was discarded")
+		}
+	})
+
+	t.Run("Dial failure is delivered to the waiter", func(t *testing.T) {
+		server := newFakeServer(t)
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		held, err := pool.GetConnection(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+
+		got := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err := pool.GetConnection(ctx)
+			got <- err
+		}()
+		waitForWaiters(t, pool, 1)
+
+		server.setPassword("changed")
+		held.Conn.Close()
+		held.Close()
+
+		select {
+		case err := <-got:
+			if err == nil || err == context.DeadlineExceeded {
+				t.Errorf("Expected bind error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Waiter was not woken after the connection was discarded")
+		}
+		if open, _ := pool.Stats(); open != 0 {
+			t.Errorf("Expected 0 open connections, got %d", open)
+		}
+	})
+
+	t.Run("Failed dial hands its slot to a waiter", func(t *testing.T) {
+		server := newFakeServer(t)
+		server.bindDelay = 100 * time.Millisecond
+		config := server.config()
+		config.MaxOpen = 1
+		pool, err := NewPool(config)
+		if err != nil {
+			t.Fatalf("Failed to create pool: %v", err)
+		}
+		defer pool.Close()
+
+		server.setPassword("changed")
+		binds := server.Requests(ldap.ApplicationBindRequest)
+		first := make(chan error, 1)
+		go func() {
+			_, err := pool.GetConnection(context.Background())
+			first <- err
+		}()
+
+		// Wait for the first caller to reserve the only slot
+		for {
+			if open, _ := pool.Stats(); open == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		second := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			conn, err := pool.GetConnection(ctx)
+			if err == nil {
+				conn.Close()
+			}
+			second <- err
+		}()
+		waitForWaiters(t, pool, 1)
+
+		// Only restore the password once the first bind has been rejected
+		for server.Requests(ldap.ApplicationBindRequest) == binds {
+			time.Sleep(time.Millisecond)
+		}
+		server.setPassword(config.AdminPass)
+
+		if err := <-first; err == nil {
+			t.Error("Expected the first dial to fail")
+		}
+		select {
+		case err := <-second:
+			if err != nil {
+				t.Errorf("Expected waiter to get a connection, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Waiter was not woken after the failed dial")
+		}
+	})
+}
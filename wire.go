@@ -0,0 +1,239 @@
+package ldapool
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// startTLSOID is the extended operation that upgrades a connection to TLS
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// wireConn is the network connection under an ldap.Conn. TLS is negotiated
+// below it, so it sees every LDAP message in plain text and records the ID of
+// the last one written, which is what an Abandon request needs.
+type wireConn struct {
+	net.Conn
+	mu     sync.Mutex
+	lastID int64
+	// nextAbandonID counts down from the top of the message ID space so
+	// abandon requests never reuse the ID of an outstanding request
+	nextAbandonID int64
+}
+
+// newWireConn wraps conn
+func newWireConn(conn net.Conn) *wireConn {
+	return &wireConn{Conn: conn, nextAbandonID: math.MaxInt32}
+}
+
+// Write writes one encoded LDAP message, recording its ID
+func (c *wireConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := messageID(b); ok {
+		atomic.StoreInt64(&c.lastID, id)
+	}
+	return c.Conn.Write(b)
+}
+
+// lastSent returns the ID of the last message written
+func (c *wireConn) lastSent() int64 {
+	return atomic.LoadInt64(&c.lastID)
+}
+
+// abandon asks the server to stop processing the request with the given ID,
+// giving up when the request cannot be written within timeout
+func (c *wireConn) abandon(id int64, timeout time.Duration) error {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger,
+		atomic.AddInt64(&c.nextAbandonID, -1), "MessageID"))
+	packet.AppendChild(ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, ldap.ApplicationAbandonRequest, id, "Abandon Request"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Lift the write deadline of an expired context for this one write
+	c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	defer c.Conn.SetWriteDeadline(time.Time{})
+	_, err := c.Conn.Write(packet.Bytes())
+	return err
+}
+
+// messageID extracts the message ID from an encoded LDAPMessage, which is a
+// SEQUENCE starting with the ID as an INTEGER
+func messageID(b []byte) (int64, bool) {
+	if len(b) < 2 || b[0] != 0x30 {
+		return 0, false
+	}
+	i := 2
+	if b[1]&0x80 != 0 {
+		i += int(b[1] & 0x7f)
+	}
+	if len(b) < i+2 || b[i] != 0x02 {
+		return 0, false
+	}
+	n := int(b[i+1])
+	i += 2
+	if n == 0 || n > 8 || len(b) < i+n {
+		return 0, false
+	}
+	var id int64
+	for _, c := range b[i : i+n] {
+		id = id<<8 | int64(c)
+	}
+	return id, true
+}
+
+// dialWire opens the network connection to rawURL, performing the TLS
+// handshake for ldaps. The schemes supported by ldap.DialURL are accepted:
+// ldap://, ldaps://, ldapi:// and cldap://. It also returns the host, for
+// verifying the certificate presented after StartTLS.
+func dialWire(ctx context.Context, rawURL string, dialer *net.Dialer, tlsConfig *tls.Config) (net.Conn, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", ldap.NewError(ldap.ErrorNetwork, err)
+	}
+
+	if u.Scheme == "ldapi" {
+		path := u.Path
+		if path == "" || path == "/" {
+			path = "/var/run/slapd/ldapi"
+		}
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		if err != nil {
+			return nil, "", ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		return conn, "", nil
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		// Assume the port is missing
+		host, port = u.Host, ""
+	}
+	network := "tcp"
+	switch u.Scheme {
+	case "cldap":
+		network = "udp"
+		fallthrough
+	case "ldap":
+		if port == "" {
+			port = ldap.DefaultLdapPort
+		}
+	case "ldaps":
+		if port == "" {
+			port = ldap.DefaultLdapsPort
+		}
+	default:
+		return nil, "", ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("unknown scheme '%s'", u.Scheme))
+	}
+
+	conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, "", ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	if u.Scheme == "ldaps" {
+		conn, err = handshake(ctx, conn, host, tlsConfig, dialer.Timeout)
+		if err != nil {
+			return nil, "", ldap.NewError(ldap.ErrorNetwork, err)
+		}
+	}
+	return conn, host, nil
+}
+
+// startTLS sends the StartTLS extended operation on conn and, once the server
+// accepts it, performs the TLS handshake. This happens before the connection
+// is handed to ldap.Conn so the wireConn can sit on top of TLS.
+func startTLS(ctx context.Context, conn net.Conn, host string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 1, "MessageID"))
+	request := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedRequest, nil, "Start TLS")
+	request.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, startTLSOID, "TLS Extended Command"))
+	packet.AppendChild(request)
+
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		conn.Close()
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	response, err := ber.ReadPacket(conn)
+	if err != nil {
+		conn.Close()
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	if err := ldap.GetLDAPError(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn, err := handshake(ctx, conn, host, config, timeout)
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("TLS handshake failed (%v)", err))
+	}
+	return tlsConn, nil
+}
+
+// handshake runs the client side of a TLS handshake on conn, closing conn if
+// it fails
+func handshake(ctx context.Context, conn net.Conn, host string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// TLSConnectionState returns the TLS state of the connection, with ok false
+// for a plain connection. It replaces the ldap.Conn method, which cannot see
+// through the wireConn.
+func (lc *LdapConn) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	if lc.wire == nil {
+		return lc.Conn.TLSConnectionState()
+	}
+	tlsConn, ok := lc.wire.Conn.(*tls.Conn)
+	if !ok {
+		return state, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
+// StartTLS fails with ErrStartTLS on connections dialed by the pool: TLS put
+// on top of the wireConn would hide the message IDs from it and abandon
+// requests would be written in plain text into the TLS stream. Set
+// UseStartTLS to have new connections upgraded before they are used.
+func (lc *LdapConn) StartTLS(config *tls.Config) error {
+	if err := lc.usable(); err != nil {
+		return err
+	}
+	if lc.wire != nil {
+		return ErrStartTLS
+	}
+	span := lc.startOp(lc.ctx, "ldap.starttls")
+	err := lc.Conn.StartTLS(config)
+	endOpSpan(span, err)
+	return err
+}